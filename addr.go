@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// The default HTTP port of nsqd, often pasted instead of the TCP one
+const nsqdHTTPPort = "4151"
+
+// NormalizeNSQD turns a URL-formatted nsqd address (tcp://host:4150)
+// into the host:port form expected by go-nsq and checks it. The http://
+// scheme is accepted as well, since the nsqd HTTP address is often pasted
+// instead, but it warns if the port is the nsqd HTTP port.
+func (c *Consumer) normalizeNSQD(addr string) (string, error) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "tcp" && u.Scheme != "http" {
+			return "", fmt.Errorf("scheme %q is not supported by nsqd, use tcp:// or host:port", u.Scheme)
+		}
+		addr = u.Host
+	}
+
+	if err := checkAddr(addr); err != nil {
+		return "", err
+	}
+
+	if _, port, _ := net.SplitHostPort(addr); port == nsqdHTTPPort {
+		c.logf(nsq.LogLevelWarning,
+			"nsqd address %s has the HTTP port, the TCP port (4150 by default) is likely intended", addr)
+	}
+
+	return addr, nil
+}
+
+// NormalizeLookupd checks an nsqlookupd address, either host:port
+// or an http:// or https:// URL. The scheme of a URL is kept, so that
+// go-nsq queries an https:// nsqlookupd over TLS.
+func normalizeLookupd(addr string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		return addr, checkAddr(addr)
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("scheme %q is not supported by nsqlookupd, use http://, https:// or host:port", u.Scheme)
+	}
+	if err := checkAddr(u.Host); err != nil {
+		return "", err
+	}
+
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// SplitAddrs is like split for address lists, but an empty entry left by
+// a stray separator (e.g. "a:4150,,b:4150" or a trailing comma) is an error.
+// The addresses are normalized by normalizeAddrs.
+func (c *Consumer) splitAddrs(value interface{}, normalize func(string) (string, error)) ([]string, error) {
+	var addrs []string
+
+	switch v := value.(type) {
+	case []string:
+		addrs = v
+	case string:
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				addrs = append(addrs, entry)
+				continue
+			}
+			addrs = append(addrs, strings.Fields(entry)...)
+		}
+	default:
+		return nil, fmt.Errorf("expected string or slice of strings")
+	}
+
+	return c.normalizeAddrs(addrs, normalize)
+}
+
+// NormalizeAddrs normalizes and checks every address with a given function.
+// In the `lenient_addresses` mode malformed addresses are only logged
+// and kept as is, and empty ones are dropped.
+func (c *Consumer) normalizeAddrs(addrs []string, normalize func(string) (string, error)) ([]string, error) {
+	res := make([]string, 0, len(addrs))
+
+	for _, a := range addrs {
+		n, err := normalize(a)
+		switch {
+		case err == nil:
+			a = n
+		case !c.lenientAddrs:
+			return nil, fmt.Errorf("invalid address %q: %v", a, err)
+		case a == "":
+			continue
+		default:
+			c.logf(nsq.LogLevelWarning, "invalid address %q: %v", a, err)
+		}
+		res = append(res, a)
+	}
+
+	return res, nil
+}
+
+// CheckAddr verifies that an address is in the host:port form
+// with a numeric port.
+func checkAddr(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("empty entry")
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+// ErrDiscoveredNSQD is returned by RemoveNSQD for an address that is not
+// configured with `nsqd` or `nsqds`. With nsqlookupds configured it is
+// presumably discovered through them, and a direct removal would be undone
+// by the next lookup poll anyway.
+var ErrDiscoveredNSQD = errors.New("nsqd is not configured directly, it may be discovered via nsqlookupd")
+
+// AddNSQD connects a running consumer to one more nsqd and adds it to the
+// configured nsqds. Before Start it only adds the address to the list.
+func (c *Consumer) AddNSQD(addr string) error {
+	n, err := c.normalizeNSQD(addr)
+	if err != nil {
+		return fmt.Errorf("invalid nsqd address %q: %v", addr, err)
+	}
+	addr = n
+
+	c.setMu.Lock()
+	c.mu.Lock()
+	configured := contains(c.nsqds, addr)
+	clients := append([]*nsq.Consumer(nil), c.clients...)
+	c.mu.Unlock()
+	c.setMu.Unlock()
+
+	if configured {
+		return nil
+	}
+
+	// The lock is not held while dialing, which takes up to dial_timeout
+	for _, client := range clients {
+		if err := c.connectNSQD(client, addr); err != nil && err != nsq.ErrAlreadyConnected {
+			return err
+		}
+	}
+
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !contains(c.nsqds, addr) {
+		c.nsqds = append(c.nsqds, addr)
+	}
+
+	return nil
+}
+
+// RemoveNSQD disconnects a running consumer from a configured nsqd and
+// removes it from the configured nsqds. Before Start it only removes
+// the address from the list. The messages in flight from the nsqd
+// cannot be responded to once it is removed.
+//
+// ErrDiscoveredNSQD is returned if the address is not in the configured
+// list but nsqlookupds are.
+func (c *Consumer) RemoveNSQD(addr string) error {
+	n, err := c.normalizeNSQD(addr)
+	if err != nil {
+		return fmt.Errorf("invalid nsqd address %q: %v", addr, err)
+	}
+	addr = n
+
+	c.setMu.Lock()
+	c.mu.Lock()
+	configured, discovers := contains(c.nsqds, addr), len(c.nsqlookupds) > 0
+	clients := append([]*nsq.Consumer(nil), c.clients...)
+	c.mu.Unlock()
+	c.setMu.Unlock()
+
+	switch {
+	case !configured && discovers:
+		return ErrDiscoveredNSQD
+	case !configured:
+		return fmt.Errorf("nsqd %s is not configured", addr)
+	}
+
+	// The lock is not held while closing the connections
+	for _, client := range clients {
+		if err := client.DisconnectFromNSQD(addr); err != nil && err != nsq.ErrNotConnected {
+			return err
+		}
+	}
+
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, a := range c.nsqds {
+		if a == addr {
+			c.nsqds = append(c.nsqds[:i:i], c.nsqds[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
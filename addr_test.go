@@ -0,0 +1,239 @@
+package consumer_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestAddNSQDDoesNotBlockWhileDialing(t *testing.T) {
+	d1 := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d1)
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d2 := newFakeNSQD(t)
+	d2.hold = make(chan struct{})
+
+	added := make(chan error, 1)
+	go func() { added <- c.AddNSQD(d2.addr()) }()
+
+	select {
+	case <-d2.identified:
+	case <-time.After(testTimeout):
+		t.Fatal("no IDENTIFY received")
+	}
+
+	got := make(chan map[string]interface{}, 1)
+	go func() { got <- c.ConfigMap() }()
+
+	select {
+	case <-got:
+	case <-time.After(testTimeout):
+		t.Fatal("the consumer is locked while dialing")
+	}
+
+	close(d2.hold)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if nsqds := c.ConfigMap()["nsqds"]; !reflect.DeepEqual(nsqds, []string{d1.addr(), d2.addr()}) {
+		t.Errorf("nsqds = %v after AddNSQD", nsqds)
+	}
+
+	if err := c.RemoveNSQD(d2.addr()); err != nil {
+		t.Fatal(err)
+	}
+	if nsqds := c.ConfigMap()["nsqds"]; !reflect.DeepEqual(nsqds, []string{d1.addr()}) {
+		t.Errorf("nsqds = %v after RemoveNSQD", nsqds)
+	}
+}
+
+func TestNSQDAddressForms(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string // empty for an error
+		warn bool
+	}{
+		{addr: "nsqd-1:4150", want: "nsqd-1:4150"},
+		{addr: "tcp://nsqd-1:4150", want: "nsqd-1:4150"},
+		{addr: "http://nsqd-1:4150", want: "nsqd-1:4150"},
+		{addr: "http://nsqd-1:4151", want: "nsqd-1:4151", warn: true},
+		{addr: "nsqd-1:4151", want: "nsqd-1:4151", warn: true},
+		{addr: "https://nsqd-1:4150"},
+		{addr: "nsqd-1"},
+	} {
+		l := &lineLogger{}
+
+		c := consumer.NewConsumer("orders", "billing")
+		c.SetLogger(l, nsq.LogLevelWarning)
+		c.Set("nsqd", tc.addr)
+
+		err := c.Validate()
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: expected an error", tc.addr)
+		case tc.want != "" && err != nil:
+			t.Errorf("%s: %v", tc.addr, err)
+		case tc.want != "" && !reflect.DeepEqual(c.ConfigMap()["nsqds"], []string{tc.want}):
+			t.Errorf("%s: nsqds = %v, want [%s]", tc.addr, c.ConfigMap()["nsqds"], tc.want)
+		}
+		if warned := l.contains("the TCP port"); warned != tc.warn {
+			t.Errorf("%s: warned = %v, want %v", tc.addr, warned, tc.warn)
+		}
+	}
+}
+
+func TestAddressValidation(t *testing.T) {
+	for _, tc := range []struct {
+		option string
+		value  string
+		want   []string // nil for an error
+	}{
+		{"nsqlookupds", "lookupd-1:4161, lookupd-2:4161", []string{"lookupd-1:4161", "lookupd-2:4161"}},
+		{"nsqlookupds", "lookupd-1:4161 lookupd-2:4161", []string{"lookupd-1:4161", "lookupd-2:4161"}},
+		{"nsqds", "[::1]:4150", []string{"[::1]:4150"}},
+		{"nsqlookupds", "lookupd-1:4161,", nil},
+		{"nsqds", "nsqd-1:4150,,nsqd-2:4150", nil},
+		{"nsqd", "10.0.0.5", nil},
+		{"nsqd", "::1:4150", nil},
+		{"nsqd", ":4150", nil},
+		{"nsqd", "nsqd-1:port", nil},
+		{"nsqd", "nsqd-1:0", nil},
+		{"nsqlookupd", "lookupd-1:65536", nil},
+		{"nsqlookupd", "", nil},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.Set(tc.option, tc.value)
+
+		err := c.Validate()
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s %q: expected an error", tc.option, tc.value)
+			} else if !strings.Contains(err.Error(), `"`+tc.option+`"`) {
+				t.Errorf("%s %q: the error does not name the option: %v", tc.option, tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", tc.option, tc.value, err)
+			continue
+		}
+
+		key := tc.option
+		if !strings.HasSuffix(key, "s") {
+			key += "s"
+		}
+		if got := c.ConfigMap()[key]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %q: got %v, want %v", tc.option, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestInvalidAddressNamed(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqds", "nsqd-1:4150, 10.0.0.5")
+
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), `"10.0.0.5"`) {
+		t.Errorf("the error does not name the address: %v", err)
+	}
+}
+
+func TestLenientAddresses(t *testing.T) {
+	l := &lineLogger{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(l, nsq.LogLevelWarning)
+	c.SetMap(map[string]interface{}{
+		"lenient_addresses": true,
+		"nsqds":             "nsqd-1:4150,,10.0.0.5,",
+	})
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.ConfigMap()["nsqds"], []string{"nsqd-1:4150", "10.0.0.5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nsqds %v, want %v", got, want)
+	}
+	if !l.contains(`invalid address "10.0.0.5"`) {
+		t.Error("the malformed address is not logged")
+	}
+}
+
+func TestAddressSchemes(t *testing.T) {
+	nsqds := []string{"nsqd-1:4150", "nsqd-2:4150"}
+	lookupds := []string{"http://lookupd-1:4161", "https://lookupd-2:4161", "lookupd-3:4161"}
+
+	for _, tc := range []struct {
+		name string
+		load func(c *consumer.Consumer) error
+	}{
+		{"Set", func(c *consumer.Consumer) error {
+			c.Set("nsqds", "tcp://nsqd-1:4150, nsqd-2:4150")
+			c.Set("nsqlookupds", "http://lookupd-1:4161 https://lookupd-2:4161/ lookupd-3:4161")
+			return nil
+		}},
+		{"slices", func(c *consumer.Consumer) error {
+			c.SetMap(map[string]interface{}{
+				"nsqds":       []string{"nsqd-1:4150", "tcp://nsqd-2:4150"},
+				"nsqlookupds": []string{"http://lookupd-1:4161", "https://lookupd-2:4161", "lookupd-3:4161"},
+			})
+			return nil
+		}},
+		{"FromEnv", func(c *consumer.Consumer) error {
+			t.Setenv("SCHEME_TEST_NSQDS", "tcp://nsqd-1:4150,tcp://nsqd-2:4150")
+			t.Setenv("SCHEME_TEST_NSQLOOKUPDS", "http://lookupd-1:4161,https://lookupd-2:4161,lookupd-3:4161")
+			return c.FromEnv("SCHEME_TEST_")
+		}},
+		{"LoadJSON", func(c *consumer.Consumer) error {
+			return c.LoadJSON(strings.NewReader(`{
+				"nsqds": ["tcp://nsqd-1:4150", "nsqd-2:4150"],
+				"nsqlookupds": "http://lookupd-1:4161, https://lookupd-2:4161, lookupd-3:4161"
+			}`))
+		}},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		if err := tc.load(c); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := c.Validate(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		cfg := c.ConfigMap()
+		if !reflect.DeepEqual(cfg["nsqds"], nsqds) {
+			t.Errorf("%s: got nsqds %v, want %v", tc.name, cfg["nsqds"], nsqds)
+		}
+		if !reflect.DeepEqual(cfg["nsqlookupds"], lookupds) {
+			t.Errorf("%s: got nsqlookupds %v, want %v", tc.name, cfg["nsqlookupds"], lookupds)
+		}
+	}
+}
+
+func TestAddressSchemeMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		option, value, entry string
+	}{
+		{"nsqds", "nsqd-1:4150, https://nsqd-2:4150", "https://nsqd-2:4150"},
+		{"nsqd", "udp://nsqd-1:4150", "udp://nsqd-1:4150"},
+		{"nsqlookupds", "lookupd-1:4161, tcp://lookupd-2:4161", "tcp://lookupd-2:4161"},
+		{"nsqlookupd", "ftp://lookupd-1:4161", "ftp://lookupd-1:4161"},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.Set(tc.option, tc.value)
+
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), strconv.Quote(tc.entry)) {
+			t.Errorf("%s %q: the error does not name %s: %v", tc.option, tc.value, tc.entry, err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package consumer
+
+// WithAtMostOnce switches the consumer to at-most-once delivery: each
+// message is finished before the handler runs.
+//
+// WARNING: this trades duplicates for data loss. If the handler fails,
+// panics or the process crashes while handling, the message is lost:
+// it has already been acknowledged and nsqd will not redeliver it.
+// Handler errors are still logged and counted, but nothing is requeued.
+// Use it only when processing a message twice is worse than not at all.
+func (c *Consumer) WithAtMostOnce() *Consumer {
+	c.Set("at_most_once", true)
+	return c
+}
@@ -0,0 +1,34 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestAtMostOnce(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing").WithAtMostOnce()
+	c.SetLogger(nil, nsq.LogLevelError)
+
+	var finishedFirst bool
+	h := consumer.HandlerFunc(func(m *nsq.Message) error {
+		finishedFirst = m.HasResponded()
+		return errors.New("boom")
+	})
+
+	d, _ := consumertest.Handle(c, h, nil)
+
+	if !finishedFirst {
+		t.Error("the message is not finished before the handler runs")
+	}
+	if !d.Finished() {
+		t.Error("the message is not finished")
+	}
+	if requeued, _, _ := d.Requeued(); requeued {
+		t.Error("the failed message is requeued")
+	}
+}
@@ -0,0 +1,19 @@
+package consumer
+
+import (
+	"math"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// WarnAttempts logs a warning for a message whose attempt counter has
+// reached the maximum of the uint16 counter nsqd keeps. The next increment
+// made by nsqd wraps it to zero, so such a message looks fresh again,
+// and max_attempts never stops it.
+func (c *Consumer) warnAttempts(m *nsq.Message) {
+	if m.Attempts == math.MaxUint16 {
+		c.logMsgf(m, nsq.LogLevelWarning,
+			"msg %s has been attempted %d times, its attempt counter will wrap; consider setting max_attempts",
+			m.ID, m.Attempts)
+	}
+}
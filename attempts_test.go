@@ -0,0 +1,54 @@
+package consumer_test
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestAttemptsWrapWarning(t *testing.T) {
+	for _, tt := range []struct {
+		attempts uint16
+		warned   bool
+	}{
+		{1, false},
+		{math.MaxUint16 - 1, false},
+		{math.MaxUint16, true},
+		{0, false}, // wrapped
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.Set("max_attempts", 0)
+		c.SetLogger(nil, nsq.LogLevelError)
+
+		var (
+			mu    sync.Mutex
+			lines []string
+		)
+		c.OnMessageLog(func(_ *nsq.Message, _ nsq.LogLevel, line string) {
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+		})
+
+		m := consumertest.NewMessage([]byte("1"), []byte("{}"))
+		m.Attempts = tt.attempts
+
+		if err := consumertest.Deliver(c, nopHandler, m); err != nil {
+			t.Fatalf("attempts %d: %v", tt.attempts, err)
+		}
+		if !consumertest.DelegateOf(m).Finished() {
+			t.Errorf("attempts %d: message is not finished", tt.attempts)
+		}
+
+		warned := len(lines) == 1 && strings.Contains(lines[0], "attempt counter will wrap")
+		if warned != tt.warned || len(lines) > 1 {
+			t.Errorf("attempts %d: got log lines %q", tt.attempts, lines)
+		}
+	}
+}
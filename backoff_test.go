@@ -0,0 +1,82 @@
+package consumer_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// CountingStrategy reports the backoff attempts go-nsq calculates.
+type countingStrategy struct {
+	attempts chan int
+}
+
+func (s *countingStrategy) Calculate(attempt int) time.Duration {
+	select {
+	case s.attempts <- attempt:
+	default:
+	}
+	return time.Millisecond
+}
+
+func TestBackoffStrategyOption(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"exponential", "*nsq.ExponentialStrategy"},
+		{"full_jitter", "*nsq.FullJitterStrategy"},
+		{&nsq.FullJitterStrategy{}, "*nsq.FullJitterStrategy"},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.Set("backoff_strategy", tt.value)
+
+		if got := c.ConfigMap()["backoff_strategy"]; got != tt.want {
+			t.Errorf("%v: got strategy %v, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffStrategyUnknown(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqlookupd", "127.0.0.1:4161")
+	c.SetMap(map[string]interface{}{"backoff_strategy": "linear"})
+
+	err := c.Start(nopHandler)
+	if err == nil {
+		c.Stop()
+		t.Fatal("expected an unknown strategy to be rejected")
+	}
+	for _, s := range []string{`"linear"`, `"exponential"`, `"full_jitter"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("the error %q does not mention %s", err, s)
+		}
+	}
+}
+
+func TestSetBackoffStrategy(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	s := &countingStrategy{attempts: make(chan int, 1)}
+	c.SetBackoffStrategy(s)
+
+	if err := c.StartFunc(func(*nsq.Message) error { return errors.New("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.expect("REQ")
+
+	select {
+	case <-s.attempts:
+	case <-time.After(testTimeout):
+		t.Fatal("the custom strategy is not used by the go-nsq client")
+	}
+}
@@ -0,0 +1,152 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// StartBatch starts the consumer with a handler of message batches.
+// Messages are buffered with the auto-response disabled and passed to fn
+// when size of them have accumulated or flushEvery has elapsed since
+// the first one, whichever comes first. On success all the messages
+// of the batch are finished, on error all of them are requeued with
+// the default delay.
+//
+// Max-in-flight is raised to size if it is lower, since the batch would
+// never fill otherwise; it is restored if the start fails. FlushEvery
+// should be well below msg_timeout, as the buffered messages are not
+// touched. Stop flushes the partial batch.
+//
+// StartBatch returns ErrAlreadyStarted for a running consumer. Once it has
+// succeeded, the consumer may be restarted with Restart, but StartBatch
+// may not be called again.
+//
+// The rest of the handler stack (validation, middlewares and so on) is
+// applied to each message before it is buffered; the message context
+// (see Context) is not available in fn.
+func (c *Consumer) StartBatch(fn func(msgs []*nsq.Message) error, size int, flushEvery time.Duration) error {
+	if size <= 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	if flushEvery <= 0 {
+		return fmt.Errorf("batch flush interval must be positive")
+	}
+
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	if c.Started() {
+		return ErrAlreadyStarted
+	}
+	if c.batching {
+		return errBatching
+	}
+
+	maxInFlight, maxInFlightSet := c.config.MaxInFlight, c.maxInFlightSet
+	if maxInFlight < size {
+		c.logf(nsq.LogLevelWarning, "max_in_flight %d is less than the batch size, raising it to %d", maxInFlight, size)
+		c.set("max_in_flight", size)
+	}
+
+	b := &batcher{c: c, fn: fn, size: size}
+
+	err := c.start(b, func() {
+		c.batching = true
+		c.onStart(func(done <-chan struct{}) { b.flushLoop(flushEvery, done) })
+		c.onStop(b.flush)
+	})
+	if err != nil {
+		c.config.MaxInFlight, c.maxInFlightSet = maxInFlight, maxInFlightSet
+	}
+
+	return err
+}
+
+var errBatching = errors.New("batch handler already started")
+
+type batcher struct {
+	c    *Consumer
+	fn   func(msgs []*nsq.Message) error
+	size int
+
+	mu      sync.Mutex
+	msgs    []*nsq.Message
+	started time.Time
+
+	// Serializes the calls of fn
+	flushMu sync.Mutex
+}
+
+func (b *batcher) HandleMessage(m *nsq.Message) error {
+	m.DisableAutoResponse()
+
+	b.mu.Lock()
+	if len(b.msgs) == 0 {
+		b.started = b.c.clock.Now()
+	}
+	b.msgs = append(b.msgs, m)
+	full := len(b.msgs) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+
+	return nil
+}
+
+// FlushLoop flushes a partial batch once it is older than a given interval.
+// It checks several times per interval, so that a batch does not wait
+// much longer than that when traffic is slow.
+func (b *batcher) flushLoop(every time.Duration, done <-chan struct{}) {
+	tick := every / 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+
+	for {
+		select {
+		case <-b.c.clock.After(tick):
+		case <-done:
+			return
+		}
+
+		b.mu.Lock()
+		due := len(b.msgs) > 0 && b.c.clock.Now().Sub(b.started) >= every
+		b.mu.Unlock()
+
+		if due {
+			b.flush()
+		}
+	}
+}
+
+func (b *batcher) flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	msgs := b.msgs
+	b.msgs = nil
+	b.mu.Unlock()
+
+	if len(msgs) == 0 {
+		return
+	}
+
+	if err := b.fn(msgs); err != nil {
+		b.c.logf(nsq.LogLevelError, "batch handler returned error for %d message(s): %v", len(msgs), err)
+		for _, m := range msgs {
+			m.Requeue(-1)
+		}
+		return
+	}
+
+	for _, m := range msgs {
+		m.Finish()
+	}
+}
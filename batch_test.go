@@ -0,0 +1,101 @@
+package consumer_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func nopBatch([]*nsq.Message) error { return nil }
+
+func TestStartBatchFailedStart(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+
+	// No nsqd or nsqlookupd is configured
+	if err := c.StartBatch(nopBatch, 10, time.Second); err == nil {
+		t.Fatal("expected the start to fail")
+	}
+	if v := c.ConfigMap()["max_in_flight"]; v != 1 {
+		t.Errorf("max_in_flight = %v after a failed start, want 1", v)
+	}
+
+	c.Set("nsqlookupd", fakeLookupd(t))
+	if err := c.StartBatch(nopBatch, 10, time.Second); err != nil {
+		t.Fatalf("start after a failed one: %v", err)
+	}
+	defer c.Stop()
+
+	if v := c.ConfigMap()["max_in_flight"]; v != 10 {
+		t.Errorf("max_in_flight = %v, want 10", v)
+	}
+}
+
+func TestStartBatchTwice(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqlookupd", fakeLookupd(t))
+
+	if err := c.StartBatch(nopBatch, 10, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if err := c.StartBatch(nopBatch, 20, time.Second); !errors.Is(err, consumer.ErrAlreadyStarted) {
+		t.Errorf("StartBatch on a running consumer = %v, want ErrAlreadyStarted", err)
+	}
+	if v := c.ConfigMap()["max_in_flight"]; v != 10 {
+		t.Errorf("max_in_flight = %v, want 10", v)
+	}
+
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartBatch(nopBatch, 10, time.Second); err == nil {
+		t.Error("expected the second StartBatch to fail")
+	}
+}
+
+func TestStartBatchFlush(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	var (
+		mu      sync.Mutex
+		batches []int
+	)
+	fn := func(msgs []*nsq.Message) error {
+		mu.Lock()
+		batches = append(batches, len(msgs))
+		mu.Unlock()
+		return nil
+	}
+
+	if err := c.StartBatch(fn, 2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", []byte("a"), 1)
+	d.publish("2", []byte("b"), 1)
+	d.expect("FIN")
+	d.expect("FIN")
+
+	// The partial batch is flushed by Stop
+	d.publish("3", []byte("c"), 1)
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || batches[0] != 2 || batches[1] != 1 {
+		t.Errorf("batches = %v, want [2 1]", batches)
+	}
+}
@@ -0,0 +1,90 @@
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// The methods below are typed shortcuts of the most common Set options.
+// Like Set, they store invalid values as errors returned by Start
+// (see Validate), and the last write of an option wins whichever way
+// it is made.
+
+// WithTopic sets the topic, the `topic` option.
+func (c *Consumer) WithTopic(topic string) *Consumer {
+	if topic == "" {
+		c.fail(fmt.Errorf("%q: must not be empty", "topic"))
+		return c
+	}
+	c.Set("topic", topic)
+	return c
+}
+
+// WithChannel sets the channel, the `channel` option.
+func (c *Consumer) WithChannel(channel string) *Consumer {
+	if channel == "" {
+		c.fail(fmt.Errorf("%q: must not be empty", "channel"))
+		return c
+	}
+	c.Set("channel", channel)
+	return c
+}
+
+// WithNSQDs sets the nsqd addresses to connect to directly,
+// the `nsqds` option.
+func (c *Consumer) WithNSQDs(addrs ...string) *Consumer {
+	if err := checkAddrList(addrs); err != nil {
+		c.fail(fmt.Errorf("%q: %v", "nsqds", err))
+		return c
+	}
+	c.Set("nsqds", addrs)
+	return c
+}
+
+// WithNSQLookupds sets the nsqlookupd addresses, the `nsqlookupds` option.
+func (c *Consumer) WithNSQLookupds(addrs ...string) *Consumer {
+	if err := checkAddrList(addrs); err != nil {
+		c.fail(fmt.Errorf("%q: %v", "nsqlookupds", err))
+		return c
+	}
+	c.Set("nsqlookupds", addrs)
+	return c
+}
+
+// WithConcurrency sets the number of concurrent handlers,
+// the `concurrency` option. Zero is an error unless WithAutoConcurrency
+// is used as well.
+func (c *Consumer) WithConcurrency(n int) *Consumer {
+	c.Set("concurrency", n)
+	return c
+}
+
+// WithMaxInFlight sets the `max_in_flight` option. It must be positive;
+// use Pause to start a consumer that does not receive messages.
+func (c *Consumer) WithMaxInFlight(n int) *Consumer {
+	if n <= 0 {
+		c.fail(fmt.Errorf("%q: must be positive (see Pause)", "max_in_flight"))
+		return c
+	}
+	c.Set("max_in_flight", n)
+	return c
+}
+
+// WithLogger is a chainable form of SetLogger.
+func (c *Consumer) WithLogger(log logger, level nsq.LogLevel) *Consumer {
+	c.SetLogger(log, level)
+	return c
+}
+
+func checkAddrList(addrs []string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("expected at least one address")
+	}
+	for _, a := range addrs {
+		if a == "" {
+			return fmt.Errorf("empty address")
+		}
+	}
+	return nil
+}
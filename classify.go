@@ -0,0 +1,162 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Action is what the consumer does with a message whose handler failed.
+type Action int
+
+const (
+	// DefaultAction requeues the message with the default go-nsq delay
+	// (which grows with the number of attempts) and triggers backoff.
+	DefaultAction Action = iota
+	// Requeue requeues the message with the Delay of the disposition.
+	Requeue
+	// Finish finishes the message, so that it will not be redelivered.
+	Finish
+)
+
+// Disposition tells the consumer how to respond to a failed message.
+//
+// For the Requeue action, a zero Delay means the default delay, the same
+// as for DefaultAction. Requeueing with an explicit delay triggers go-nsq
+// backoff as usual.
+type Disposition struct {
+	Action Action
+	Delay  time.Duration
+}
+
+// PermanentError marks a handler error that will never succeed on retry,
+// see Permanent.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps a handler error so that the message is finished instead
+// of being requeued, e.g. for a malformed message or one of a deleted tenant.
+// With the `permanent_give_up` option the message is also given up like
+// one exceeded max_attempts: it is passed to the OnGiveUp callbacks,
+// quarantined and republished to the dead-letter topic if configured.
+//
+// It takes effect regardless of the attempts left, and the classifier
+// is not consulted.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// RequeueError requests a specific requeue delay of a failed message,
+// see RequeueAfter.
+type RequeueError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("%v (requeue after %s)", e.Err, e.Delay)
+}
+
+func (e *RequeueError) Unwrap() error { return e.Err }
+
+// RequeueAfter wraps a handler error so that the message is requeued
+// with a given delay, triggering go-nsq backoff as usual. The classifier
+// and the requeue delay function are not consulted.
+//
+// The redelivery still counts towards max_attempts: once they are
+// exceeded, the message is given up on its next delivery before
+// the handler is called (see OnGiveUp).
+func RequeueAfter(err error, delay time.Duration) error {
+	return &RequeueError{Err: err, Delay: delay}
+}
+
+// Failures returns the number of handler errors by kind: permanent ones
+// (see Permanent) and transient ones, which are all the rest.
+func (c *Consumer) Failures() (permanent, transient uint64) {
+	return c.activity.permanent.Load(), c.activity.transient.Load()
+}
+
+// ErrorClassifier maps a handler error to a disposition.
+type ErrorClassifier func(err error) Disposition
+
+// WithErrorClassifier sets a function that decides what to do with messages
+// whose handler returned an error, e.g. requeue rate-limited ones with
+// a longer delay than those failed on a transient network error.
+//
+// Without a classifier every failed message gets the DefaultAction.
+func (c *Consumer) WithErrorClassifier(fn ErrorClassifier) *Consumer {
+	c.classifier = fn
+	return c
+}
+
+// SetRequeueDelayFunc sets a function computing the requeue delay of a failed
+// message, e.g. from its Attempts, instead of the default go-nsq delay.
+//
+// The message is then requeued with RequeueWithoutBackoff: the
+// `default_requeue_delay` and `max_requeue_delay` options do not apply,
+// and go-nsq backoff (slowing down the whole consumer after failures)
+// is not triggered. Without the function, go-nsq requeues the message with
+// a delay growing with attempts and applies backoff.
+//
+// It applies only to errors the classifier, if any, maps to DefaultAction:
+// errors it maps to Finish or to Requeue with a Delay bypass the function.
+func (c *Consumer) SetRequeueDelayFunc(fn func(m *nsq.Message) time.Duration) {
+	c.requeueDelay = fn
+}
+
+// Respond responds to a failed message according to its error type
+// or the disposition of its error. The auto-response is disabled
+// if the message is responded to here.
+func (h *wrappedHandler) respond(m *nsq.Message, err error) {
+	c := h.c
+
+	var pe *PermanentError
+	if errors.As(err, &pe) {
+		c.activity.permanent.Add(1)
+	} else {
+		c.activity.transient.Add(1)
+	}
+
+	if m.IsAutoResponseDisabled() {
+		return
+	}
+
+	var re *RequeueError
+	switch {
+	case pe != nil:
+		m.DisableAutoResponse()
+		if c.permanentGiveUp {
+			h.LogFailedMessage(m)
+		}
+		m.Finish()
+		return
+	case errors.As(err, &re):
+		m.DisableAutoResponse()
+		m.Requeue(re.Delay)
+		return
+	}
+
+	var d Disposition
+	if c.classifier != nil {
+		d = c.classifier(err)
+	}
+
+	switch {
+	case d.Action == Requeue && d.Delay > 0:
+		m.DisableAutoResponse()
+		m.Requeue(d.Delay)
+	case d.Action == Finish:
+		m.DisableAutoResponse()
+		m.Finish()
+	case c.requeueDelay != nil:
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(c.requeueDelay(m))
+	}
+}
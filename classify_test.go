@@ -0,0 +1,60 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+var (
+	errRateLimited = errors.New("rate limited")
+	errNetwork     = errors.New("connection reset")
+	errMalformed   = errors.New("malformed")
+)
+
+func TestErrorClassifierDelays(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing").WithErrorClassifier(func(err error) consumer.Disposition {
+		switch {
+		case errors.Is(err, errRateLimited):
+			return consumer.Disposition{Action: consumer.Requeue, Delay: 30 * time.Second}
+		case errors.Is(err, errNetwork):
+			return consumer.Disposition{Action: consumer.Requeue, Delay: time.Second}
+		case errors.Is(err, errMalformed):
+			return consumer.Disposition{Action: consumer.Finish}
+		}
+		return consumer.Disposition{}
+	})
+
+	for _, tc := range []struct {
+		err      error
+		finished bool
+		delay    time.Duration
+	}{
+		{err: errRateLimited, delay: 30 * time.Second},
+		{err: errNetwork, delay: time.Second},
+		{err: errMalformed, finished: true},
+		{err: errors.New("other"), delay: -1},
+	} {
+		h := consumer.HandlerFunc(func(*nsq.Message) error { return tc.err })
+
+		d, err := consumertest.Handle(c, h, nil)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%v: got error %v", tc.err, err)
+		}
+
+		requeued, delay, _ := d.Requeued()
+		switch {
+		case d.Finished() != tc.finished:
+			t.Errorf("%v: finished = %v, want %v", tc.err, d.Finished(), tc.finished)
+		case !tc.finished && !requeued:
+			t.Errorf("%v: the message is not requeued", tc.err)
+		case !tc.finished && delay != tc.delay:
+			t.Errorf("%v: requeued with delay %s, want %s", tc.err, delay, tc.delay)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"github.com/nsqio/go-nsq"
+)
+
+// Client returns the underlying NSQ Consumer of the `topic` option,
+// or nil before Start. After Stop it is the stopped one until a restart.
+//
+// It is an escape hatch for what the wrapper does not provide. Reading
+// methods such as Stats and IsStarved are safe to call. The wrapper keeps
+// its own state of the rest, so prefer its methods where they exist:
+//   - ChangeMaxInFlight is overridden by Pause, Resume, ProcessN
+//     and SetConcurrencyLive;
+//   - ConnectToNSQD and DisconnectFromNSQD are not reflected in the nsqds
+//     known to the wrapper (see AddNSQD and RemoveNSQD), and a failed
+//     ConnectToNSQD holds back the OnConnect callbacks of the client;
+//   - Stop bypasses the drain, hooks and cleanup of Stop;
+//   - AddHandler panics once connected.
+func (c *Consumer) Client() *nsq.Consumer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.clients) == 0 {
+		return nil
+	}
+	return c.clients[0]
+}
+
+// Clients is like Client but returns the NSQ Consumers of all topics
+// in the order of Topics().
+func (c *Consumer) Clients() []*nsq.Consumer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*nsq.Consumer(nil), c.clients...)
+}
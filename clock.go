@@ -0,0 +1,22 @@
+package consumer
+
+import "time"
+
+// Clock is the source of time for all time-based features of the consumer.
+// It can be replaced in tests to make them deterministic.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock replaces the real clock. It is intended for tests only,
+// the external ones reach it through export_test.go.
+func (c *Consumer) withClock(cl clock) *Consumer {
+	c.clock = cl
+	return c
+}
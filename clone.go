@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"reflect"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Clone returns an unstarted copy of the consumer configuration, e.g. to
+// create consumers of several topics or channels from a template and then
+// override them with Set. The copy has its own nsq.Config and slices,
+// so changing one of them does not affect the other.
+//
+// The options, the configuration errors, the middlewares, and the hooks and
+// callbacks are copied, except for those of OnReady. A started consumer may
+// be cloned as well: the copy starts over with no connections, counters
+// or message budget.
+func (c *Consumer) Clone() *Consumer {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := NewConsumer(c.topic, c.channel)
+
+	n.config = cloneConfig(c.config)
+	n.nsqds = append([]string(nil), c.nsqds...)
+	n.nsqlookupds = append([]string(nil), c.nsqlookupds...)
+	n.concurrency = c.concurrency
+	n.topics = append([]string(nil), c.topics...)
+	n.level = c.level
+	n.log = c.log
+	n.errs = c.configErrors()
+
+	n.lookupdFallback = c.lookupdFallback
+	n.lenientAddrs = c.lenientAddrs
+
+	n.nsqdSRV = c.nsqdSRV
+	n.lookupdSRV = c.lookupdSRV
+	n.discoveryInterval = c.discoveryInterval
+	n.dnsResolver = c.dnsResolver
+	n.resolver = c.resolver
+	n.autoConcurrency = c.autoConcurrency
+	n.gzip = c.gzip
+	n.recoverPanics = c.recoverPanics
+	n.sharded = c.sharded
+	n.shardKey = c.shardKey
+	n.shardQueueSize = c.shardQueueSize
+	n.panicErrorLimit = c.panicErrorLimit
+	n.permanentGiveUp = c.permanentGiveUp
+	n.atMostOnce = c.atMostOnce
+	n.maxHandlers = c.maxHandlers
+	n.maxInFlightBytes = c.maxInFlightBytes
+	n.maxInFlightAuto = c.maxInFlightAuto
+	n.maxInFlightSet = c.maxInFlightSet
+	n.validator = c.validator
+	n.httpClient = c.httpClient
+	n.tlsFiles = c.tlsFiles
+	n.deadLetter.topic = c.deadLetter.topic
+	n.deadLetter.addr = c.deadLetter.addr
+	n.deadLetter.retries = c.deadLetter.retries
+	n.envelopeBuilder = c.envelopeBuilder
+	n.requeueDelay = c.requeueDelay
+	n.classifier = c.classifier
+	n.badMessage = c.badMessage
+	n.quarantineDir = c.quarantineDir
+	n.msgLogHooks = append(n.msgLogHooks, c.msgLogHooks...)
+	n.finishHooks = append(n.finishHooks, c.finishHooks...)
+	n.requeueHooks = append(n.requeueHooks, c.requeueHooks...)
+	n.touchHooks = append(n.touchHooks, c.touchHooks...)
+	n.giveUpCbs = append(n.giveUpCbs, c.giveUpCbs...)
+	n.observer = c.observer
+	n.panicCbs = append(n.panicCbs, c.panicCbs...)
+	n.middlewares = append(n.middlewares, c.middlewares...)
+
+	n.ctxFromMsgTimeout = c.ctxFromMsgTimeout
+	n.msgTimeoutMargin = c.msgTimeoutMargin
+
+	n.starvationInterval = c.starvationInterval
+	n.drainLogInterval = c.drainLogInterval
+	n.handlerTimeout = c.handlerTimeout
+	n.touch = c.touch
+
+	n.pushURL = c.pushURL
+	n.pushJob = c.pushJob
+	n.pushInterval = c.pushInterval
+
+	n.msgAge = newHistogram(c.msgAge.bounds)
+	n.handlerDur = newHistogram(c.handlerDur.bounds)
+	n.clock = c.clock
+	n.rateRPS = c.rateRPS
+	n.rateBurst = c.rateBurst
+	n.applyRateLimit()
+
+	n.paused = c.paused && c.state == stateCreated
+
+	c.cbMu.Lock()
+	n.starvationCbs = append(n.starvationCbs, c.starvationCbs...)
+	n.connectCbs = append(n.connectCbs, c.connectCbs...)
+	n.disconnectCbs = append(n.disconnectCbs, c.disconnectCbs...)
+	for _, e := range c.errRates {
+		n.errRates = append(n.errRates, &errorRate{threshold: e.threshold, window: e.window, cb: e.cb})
+	}
+	c.cbMu.Unlock()
+
+	return n
+}
+
+// CloneConfig copies the options of an nsq.Config into a new one,
+// which has the internal state of its own. The TLS config is cloned.
+func cloneConfig(cfg *nsq.Config) *nsq.Config {
+	n := nsq.NewConfig()
+
+	src, dst := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(n).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if dst.Field(i).CanSet() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+
+	if cfg.TlsConfig != nil {
+		n.TlsConfig = cfg.TlsConfig.Clone()
+	}
+
+	return n
+}
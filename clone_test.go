@@ -0,0 +1,138 @@
+package consumer_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestCloneIsolation(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetMap(map[string]interface{}{
+		"nsqds":         "nsqd-1:4150, nsqd-2:4150",
+		"nsqlookupds":   "lookupd-1:4161",
+		"max_in_flight": 8,
+		"concurrency":   4,
+	})
+
+	clone := c.Clone()
+	clone.SetMap(map[string]interface{}{
+		"topic":         "invoices",
+		"channel":       "archive",
+		"max_in_flight": 16,
+		"nsqlookupd":    "lookupd-2:4161",
+	})
+	if err := clone.AddNSQD("nsqd-3:4150"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddNSQD("nsqd-4:4150"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		c    *consumer.Consumer
+		want map[string]interface{}
+	}{
+		{c, map[string]interface{}{
+			"topic":         "orders",
+			"channel":       "billing",
+			"nsqds":         []string{"nsqd-1:4150", "nsqd-2:4150", "nsqd-4:4150"},
+			"nsqlookupds":   []string{"lookupd-1:4161"},
+			"max_in_flight": 8,
+			"concurrency":   4,
+		}},
+		{clone, map[string]interface{}{
+			"topic":         "invoices",
+			"channel":       "archive",
+			"nsqds":         []string{"nsqd-1:4150", "nsqd-2:4150", "nsqd-3:4150"},
+			"nsqlookupds":   []string{"lookupd-2:4161"},
+			"max_in_flight": 16,
+			"concurrency":   4,
+		}},
+	} {
+		cfg := tt.c.ConfigMap()
+		for opt, want := range tt.want {
+			if !reflect.DeepEqual(cfg[opt], want) {
+				t.Errorf("%s/%s: %s = %v, want %v", cfg["topic"], cfg["channel"], opt, cfg[opt], want)
+			}
+		}
+	}
+}
+
+func TestCloneErrors(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqlookupd", "127.0.0.1:4161")
+	c.Set("max_in_flight", "many")
+
+	clone := c.Clone()
+	if err := clone.Validate(); err == nil || !strings.Contains(err.Error(), "max_in_flight") {
+		t.Errorf("the configuration error is not copied: %v", err)
+	}
+
+	// Later errors of the original are not reported by the clone
+	n := consumer.NewConsumer("orders", "billing")
+	n.Set("nsqlookupd", "127.0.0.1:4161")
+	later := n.Clone()
+	n.Set("max_in_flight", "many")
+	if err := later.Validate(); err != nil {
+		t.Errorf("an error of the original is reported by the clone: %v", err)
+	}
+}
+
+func TestCloneMiddlewares(t *testing.T) {
+	var order []string
+	mark := func(name string) consumer.Middleware {
+		return func(next nsq.Handler) nsq.Handler {
+			return consumer.HandlerFunc(func(m *nsq.Message) error {
+				order = append(order, name)
+				return next.HandleMessage(m)
+			})
+		}
+	}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(mark("template"))
+
+	clone := c.Clone()
+	clone.Use(mark("clone"))
+
+	for _, tt := range []struct {
+		c    *consumer.Consumer
+		want []string
+	}{
+		{c, []string{"template"}},
+		{clone, []string{"template", "clone"}},
+	} {
+		order = nil
+		if _, err := consumertest.Handle(tt.c, nopHandler, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(order, tt.want) {
+			t.Errorf("got middlewares %v, want %v", order, tt.want)
+		}
+	}
+}
+
+func TestCloneStarted(t *testing.T) {
+	c := startedConsumer(t, nopHandler, nil)
+
+	clone := c.Clone()
+	if clone.Started() {
+		t.Fatal("the clone of a started consumer is started")
+	}
+
+	clone.Set("channel", "archive")
+	if err := clone.Start(nopHandler); err != nil {
+		t.Fatalf("start the clone: %v", err)
+	}
+	clone.Stop()
+
+	if !c.Started() {
+		t.Error("the original is stopped with its clone")
+	}
+}
@@ -1,10 +1,19 @@
 package consumer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nsqio/go-nsq"
 )
@@ -15,158 +24,1235 @@ type logger interface {
 
 // Consumer is a convenient layer to the standard NSQ Consumer.
 type Consumer struct {
-	client      *nsq.Consumer
+	clients     []*nsq.Consumer
+	done        chan struct{}
 	config      *nsq.Config
 	nsqds       []string
 	nsqlookupds []string
 	concurrency int
 	channel     string
 	topic       string
+	topics      []string
 	level       nsq.LogLevel
 	log         logger
-	err         error
+
+	// Serialises the configuration methods and Start,
+	// so that the options are never read while being changed
+	setMu sync.Mutex
+	// Protects errs, which are also recorded outside of Set
+	errMu sync.Mutex
+	errs  []error
+
+	lookupdFallback bool
+	lenientAddrs    bool
+
+	nsqdSRV           string
+	lookupdSRV        string
+	discoveryInterval time.Duration
+	dnsResolver       DNSResolver
+	resolver          Resolver
+	discovered        discovered
+	autoConcurrency   bool
+	gzip              bool
+	recoverPanics     bool
+	sharded           bool
+	shardKey          func(*nsq.Message) string
+	shardQueueSize    int
+	panicErrorLimit   int
+	permanentGiveUp   bool
+	atMostOnce        bool
+	maxHandlers       int
+	maxInFlightBytes  int64
+	maxInFlightAuto   bool
+	maxInFlightSet    bool
+	validator         Validator
+	httpClient        *http.Client
+	tlsFiles          tlsFiles
+	deadLetter        deadLetter
+	envelopeBuilder   EnvelopeBuilder
+	requeueDelay      func(m *nsq.Message) time.Duration
+	classifier        ErrorClassifier
+	badMessage        BadMessagePolicy
+	quarantineDir     string
+	msgLogHooks       []func(*nsq.Message, nsq.LogLevel, string)
+	finishHooks       []func(*nsq.Message)
+	requeueHooks      []func(*nsq.Message, time.Duration)
+	touchHooks        []func(*nsq.Message)
+	giveUpCbs         []func(*nsq.Message)
+	observer          Observer
+	panicCbs          []func(*nsq.Message, interface{}, []byte)
+	middlewares       []namedMiddleware
+
+	ctxFromMsgTimeout bool
+	msgTimeoutMargin  time.Duration
+
+	starvationInterval time.Duration
+	drainLogInterval   time.Duration
+	handlerTimeout     time.Duration
+	touch              autoTouch
+
+	pushURL      string
+	pushJob      string
+	pushInterval time.Duration
+
+	baseCtx    context.Context
+	cancelBase context.CancelCauseFunc
+
+	msgAge       *histogram
+	handlerDur   *histogram
+	activity     activity
+	abandoned    abandoned
+	asyncErrs    asyncErrors
+	clock        clock
+	limit        atomic.Pointer[msgLimit]
+	rateLimit    atomic.Pointer[tokenBucket]
+	rateRPS      float64
+	rateBurst    int
+	bodyCounters bodyCounters
+
+	// Set once the timeout of Drain has expired, until the next start
+	drainExpired atomic.Bool
+
+	inFlight      inFlight
+	inFlightBytes int64
+
+	mu       sync.Mutex
+	state    int
+	handler  nsq.Handler
+	stopDone chan struct{}
+	// Set by stop before closing stopDone, new for every run
+	stopErr *error
+	pool    *pool
+	paused  bool
+
+	// Closed once a run is over (see Done)
+	terminated chan struct{}
+	termErr    error
+	// Background goroutines of the current run, waited for by stop
+	bg sync.WaitGroup
+
+	// Called once the clients are stopping
+	stopHooks []func()
+	// Called on every start with the channel closed once stopped
+	startHooks []func(done <-chan struct{})
+	// Set once StartBatch has succeeded
+	batching bool
+
+	// Protects callbacks
+	cbMu     sync.Mutex
+	isReady  bool
+	readyCbs []func()
+
+	starvationCbs []func()
+	errRates      []*errorRate
+	connectCbs    []func(addr string)
+	disconnectCbs []func(addr string)
+
+	// Follows the nsqd connections of the current run, if OnConnect
+	// or OnDisconnect callbacks are registered
+	peers atomic.Pointer[peerSet]
 }
 
+// The lifecycle states of a Consumer
+const (
+	stateCreated = iota
+	stateStarted
+	stateStopped
+)
+
+var (
+	// ErrAlreadyStarted is returned by Start if the consumer is running.
+	ErrAlreadyStarted = errors.New("consumer already started")
+
+	// ErrNotStarted is returned by Stop and Restart if the consumer
+	// has never been started, and by Stop if the last Start has failed.
+	ErrNotStarted = errors.New("consumer not started")
+
+	// ErrStopped is returned by ProcessN if the consumer is stopped
+	// before the messages have been processed.
+	ErrStopped = errors.New("consumer stopped")
+
+	errStopping   = errors.New("consumer is still stopping")
+	errNilHandler = errors.New("handler must not be nil")
+)
+
+// DefaultDrainLogInterval is how often Stop() logs the number of messages
+// still in flight while waiting for them.
+const DefaultDrainLogInterval = 5 * time.Second
+
 // NewConsumer returns a new consumer of a given topic and channel.
 func NewConsumer(topic, channel string) *Consumer {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
 	return &Consumer{
+		baseCtx:     ctx,
+		cancelBase:  cancel,
 		log:         log.New(os.Stderr, "", log.LstdFlags),
 		config:      nsq.NewConfig(),
 		level:       nsq.LogLevelInfo,
 		channel:     channel,
 		topic:       topic,
 		concurrency: 1,
+		msgAge:      newHistogram(DefaultMessageAgeBuckets),
+		handlerDur:  newHistogram(DefaultHandlerDurationBuckets),
+		clock:       realClock{},
+
+		drainLogInterval: DefaultDrainLogInterval,
+		deadLetter:       deadLetter{retries: DefaultDeadLetterRetries},
+		asyncErrs:        newAsyncErrors(),
+		terminated:       make(chan struct{}),
 	}
 }
 
 // SetLogger replaces the default NSQ logger.
+//
+// A nil logger (including a typed nil such as (*log.Logger)(nil))
+// disables logging instead of causing a panic later on.
 func (c *Consumer) SetLogger(log logger, level nsq.LogLevel) {
+	if isNil(log) {
+		log = nopLogger{}
+	}
 	c.level = level
 	c.log = log
 }
 
-// SetMap applies all options at once.
+type nopLogger struct{}
+
+func (nopLogger) Output(int, string) error { return nil }
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Func, reflect.Chan, reflect.Interface, reflect.Slice:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// SetMap applies all options at once: a concurrent Start sees
+// either none or all of them.
 func (c *Consumer) SetMap(options map[string]interface{}) {
-	for k, v := range options {
-		c.Set(k, v)
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	// Sorted, so that the errors are reported in a stable order
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c.set(k, options[k])
 	}
 }
 
 // Set takes an option as a string and a value as an interface
 // and trying to set the appropriate option of consumer or its configuration.
 //
-// Any error will be returned in the Start() function.
+// Errors are accumulated, and all of them will be returned
+// in the Start() function.
+//
+// Integer and boolean options may also be given as strings, e.g. "8" or "true",
+// as may the options of nsq.Config, which also parses durations like "30s".
 //
 // The following consumer options is implemented:
 //
 //  - `topic` consumer topic
+//  - `topics` additional topics separated by comma or space (see AddTopic)
 //  - `channel` consumer channel
-//  - `nsqd` nsqd address
+//  - `nsqd` nsqd address (host:port or tcp://host:port)
 //  - `nsqds` nsqd addresses separated by comma or space
-//  - `nsqlookupd` nsqlookupd address
+//  - `nsqlookupd` nsqlookupd address (host:port, http:// or https:// URL)
 //  - `nsqlookupds` nsqlookupd addresses separated by comma or space
 //  - `concurrency` concurrent handlers (default: 1)
+//  - `auto_concurrency` treat `concurrency` 0 as GOMAXPROCS (default: false)
+//  - `at_most_once` finish messages before handling them (see WithAtMostOnce)
+//  - `gzip` decompress gzip message bodies (default: false)
+//  - `sharded` handle messages of the same key in order (see SetShardKeyFunc)
+//  - `shard_queue_size` limit of messages queued per shard (default: 16)
+//  - `recover_panics` turn handler panics into errors (default: false; see Recoverer)
+//  - `permanent_give_up` give up messages failed with Permanent errors (default: false; see Permanent)
+//  - `panic_error_limit` number of panics reported to Errors() at once (default: 10)
+//  - `msg_timeout_margin` safety margin of the handler deadline (see WithContextFromMsgTimeout)
+//  - `push_interval` interval of pushing metrics to the Pushgateway (see WithPushGateway)
+//  - `starvation_poll_interval` interval of starvation checks (see OnStarvation)
+//  - `drain_log_interval` interval of progress logging during Stop() (default: 5s, 0 disables)
+//  - `nsqd_srv` DNS SRV name resolved into nsqd addresses at start
+//  - `nsqlookupd_srv` DNS SRV name resolved into nsqlookupd addresses at start
+//  - `discovery_interval` interval of re-resolving the SRV names and the Resolver (default: 0, only at start)
+//  - `dns_resolver` DNSResolver of the SRV names (see SetDNSResolver)
+//  - `resolver` Resolver of additional addresses (see SetResolver)
+//  - `lenient_addresses` only warn about malformed nsqd/nsqlookupd addresses (default: false)
+//  - `lookupd_fallback` use nsqds only if nsqlookupds are unreachable at start (default: false)
+//  - `max_concurrent_handlers` limit of simultaneously running handler functions (default: 0, no limit)
+//  - `max_in_flight_auto` raise max_in_flight to the concurrency unless it is set explicitly (default: false)
+//  - `max_in_flight_bytes` limit of the total body size of messages being handled (default: 0, no limit)
+//  - `message_age_buckets` message age histogram buckets separated by comma or space
+//  - `validator` message body validator (see WithValidator)
+//  - `lookupd_http_client` *http.Client used for nsqlookupd queries
+//  - `backoff_strategy` "exponential" (default), "full_jitter" or an nsq.BackoffStrategy
+//  - `auto_touch` touch messages while they are handled (default: false; see EnableAutoTouch)
+//  - `auto_touch_interval` interval of auto-touch (default: a bit under msg_timeout)
+//  - `auto_touch_max` limit of the time a message is kept alive by auto-touch (default: 15m)
+//  - `handler_timeout` limit of the handler time per message (default: 0, no limit; see SetHandlerTimeout)
+//  - `max_rate` limit of messages handled per second (default: 0, no limit; see SetRateLimit)
+//  - `max_rate_burst` burst of the `max_rate` limit (default: 1)
+//  - `dead_letter_topic` topic to republish messages exceeded max_attempts to (see SetDeadLetter)
+//  - `dead_letter_nsqd` nsqd address of the dead-letter topic (default: the first of nsqds)
+//  - `dead_letter_retries` retries of a failed dead-letter publish (default: 3)
+//  - `tls_cert_file`, `tls_key_file` client certificate and key PEM files (enable `tls_v1`)
+//  - `tls_ca_file` PEM file of the CA certificates to verify nsqd with (enables `tls_v1`)
+//
+// Pointers to plain values (e.g. *string, *int) are dereferenced,
+// and a nil pointer of such a type leaves the option unset.
+//
+// All options are immutable once Start() is called, since the underlying
+// NSQ Consumer has already captured them (along with a copy of the config).
+// Setting an option after start is logged and ignored; use Started() to check.
+// It does not affect the next Start, so that options may be changed between
+// Stop and Start. The exceptions are `max_rate` and `max_rate_burst`,
+// which take effect immediately.
+//
+// Set, SetMap and Start are safe for concurrent use.
 func (c *Consumer) Set(option string, value interface{}) {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	c.set(option, value)
+}
+
+// RuntimeOptions may be changed by Set while the consumer is running.
+var runtimeOptions = map[string]bool{
+	"max_rate":       true,
+	"max_rate_burst": true,
+}
+
+// Immutable returns an error if an option cannot be changed,
+// since the consumer is running.
+func (c *Consumer) immutable(option string) error {
+	c.mu.Lock()
+	started := c.active()
+	c.mu.Unlock()
+
+	if started && !runtimeOptions[option] {
+		return fmt.Errorf("cannot set %q: consumer already started", option)
+	}
+	return nil
+}
+
+// Set is Set with c.setMu held.
+func (c *Consumer) set(option string, value interface{}) {
+	value, ok := deref(value)
+	if !ok {
+		return
+	}
+	if s, ok := value.(string); ok {
+		value = coerceString(option, s)
+	}
+
+	if err := c.immutable(option); err != nil {
+		c.logf(nsq.LogLevelWarning, "%v", err)
+		return
+	}
+
 	switch option {
 	case "topic":
 		if s, ok := value.(string); ok {
 			c.topic = s
 		} else {
-			c.err = fmt.Errorf("%q: expected string", option)
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "topics":
+		if s, err := split(value); err == nil {
+			c.topics = nil
+			for _, t := range s {
+				c.addTopic(t)
+			}
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
 			return
 		}
 	case "channel":
 		if s, ok := value.(string); ok {
 			c.channel = s
 		} else {
-			c.err = fmt.Errorf("%q: expected string", option)
+			c.fail(fmt.Errorf("%q: expected string", option))
 			return
 		}
 	case "concurrency":
 		if s, ok := value.(int); ok {
 			c.concurrency = s
 		} else {
-			c.err = fmt.Errorf("%q: expected integer", option)
+			c.fail(fmt.Errorf("%q: expected integer", option))
 			return
 		}
 	case "nsqd":
 		if s, ok := value.(string); ok {
-			c.nsqds = []string{s}
+			if s, err := c.normalizeAddrs([]string{s}, c.normalizeNSQD); err == nil {
+				c.nsqds = s
+			} else {
+				c.fail(fmt.Errorf("%q: %v", option, err))
+				return
+			}
 		} else {
-			c.err = fmt.Errorf("%q: expected string", option)
+			c.fail(fmt.Errorf("%q: expected string", option))
 			return
 		}
 	case "nsqlookupd":
 		if s, ok := value.(string); ok {
-			c.nsqlookupds = []string{s}
+			if s, err := c.normalizeAddrs([]string{s}, normalizeLookupd); err == nil {
+				c.nsqlookupds = s
+			} else {
+				c.fail(fmt.Errorf("%q: %v", option, err))
+				return
+			}
 		} else {
-			c.err = fmt.Errorf("%q: expected string", option)
+			c.fail(fmt.Errorf("%q: expected string", option))
 			return
 		}
 	case "nsqds":
-		if s, err := split(value); err == nil {
+		if s, err := c.splitAddrs(value, c.normalizeNSQD); err == nil {
 			c.nsqds = s
 		} else {
-			c.err = fmt.Errorf("%q: %v", option, err)
+			c.fail(fmt.Errorf("%q: %v", option, err))
 			return
 		}
 	case "nsqlookupds":
-		if s, err := split(value); err == nil {
+		if s, err := c.splitAddrs(value, normalizeLookupd); err == nil {
 			c.nsqlookupds = s
 		} else {
-			c.err = fmt.Errorf("%q: %v", option, err)
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "nsqd_srv":
+		if s, ok := value.(string); ok {
+			c.nsqdSRV = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "nsqlookupd_srv":
+		if s, ok := value.(string); ok {
+			c.lookupdSRV = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "discovery_interval":
+		if d, err := duration(value); err == nil && d >= 0 {
+			c.discoveryInterval = d
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative duration", option))
+			return
+		}
+	case "dns_resolver":
+		if r, ok := value.(DNSResolver); ok && !isNil(r) {
+			c.dnsResolver = r
+		} else {
+			c.fail(fmt.Errorf("%q: expected DNSResolver", option))
+			return
+		}
+	case "resolver":
+		if r, ok := value.(Resolver); ok && !isNil(r) {
+			c.resolver = r
+		} else {
+			c.fail(fmt.Errorf("%q: expected Resolver", option))
+			return
+		}
+	case "lenient_addresses":
+		if s, ok := value.(bool); ok {
+			c.lenientAddrs = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "auto_concurrency":
+		if s, ok := value.(bool); ok {
+			c.autoConcurrency = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "at_most_once":
+		if s, ok := value.(bool); ok {
+			c.atMostOnce = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "sharded":
+		if s, ok := value.(bool); ok {
+			c.sharded = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "shard_queue_size":
+		if s, ok := value.(int); ok && s >= 0 {
+			c.shardQueueSize = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative integer", option))
+			return
+		}
+	case "permanent_give_up":
+		if s, ok := value.(bool); ok {
+			c.permanentGiveUp = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "panic_error_limit":
+		if s, ok := value.(int); ok && s >= 0 {
+			c.panicErrorLimit = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative integer", option))
+			return
+		}
+	case "recover_panics":
+		if s, ok := value.(bool); ok {
+			c.recoverPanics = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "gzip":
+		if s, ok := value.(bool); ok {
+			c.gzip = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "msg_timeout_margin":
+		if d, err := duration(value); err == nil {
+			c.msgTimeoutMargin = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "push_interval":
+		if d, err := duration(value); err == nil {
+			c.pushInterval = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "starvation_poll_interval":
+		if d, err := duration(value); err == nil {
+			c.starvationInterval = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "drain_log_interval":
+		if d, err := duration(value); err == nil {
+			c.drainLogInterval = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "lookupd_fallback":
+		if s, ok := value.(bool); ok {
+			c.lookupdFallback = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "max_concurrent_handlers":
+		if s, ok := value.(int); ok && s >= 0 {
+			c.maxHandlers = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative integer", option))
+			return
+		}
+	case "max_in_flight_bytes":
+		switch v := value.(type) {
+		case int64:
+			c.maxInFlightBytes = v
+		case int:
+			c.maxInFlightBytes = int64(v)
+		default:
+			c.fail(fmt.Errorf("%q: expected integer", option))
+			return
+		}
+	case "message_age_buckets":
+		if b, err := parseBuckets(value); err == nil {
+			c.msgAge = newHistogram(b)
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "validator":
+		if v, ok := value.(Validator); ok {
+			c.validator = v
+		} else {
+			c.fail(fmt.Errorf("%q: expected Validator", option))
+			return
+		}
+	case "tls_cert_file", "tls_key_file", "tls_ca_file":
+		if s, ok := value.(string); ok {
+			if err := c.setTLSFile(option, s); err != nil {
+				c.fail(fmt.Errorf("%q: %v", option, err))
+				return
+			}
+		} else {
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "dead_letter_topic":
+		if s, ok := value.(string); ok {
+			c.deadLetter.topic = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "dead_letter_nsqd":
+		if s, ok := value.(string); ok {
+			if s, err := c.normalizeAddrs([]string{s}, c.normalizeNSQD); err == nil {
+				c.deadLetter.addr = s[0]
+			} else {
+				c.fail(fmt.Errorf("%q: %v", option, err))
+				return
+			}
+		} else {
+			c.fail(fmt.Errorf("%q: expected string", option))
+			return
+		}
+	case "dead_letter_retries":
+		if s, ok := value.(int); ok && s >= 0 {
+			c.deadLetter.retries = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative integer", option))
+			return
+		}
+	case "max_rate":
+		if f, err := float(value); err == nil {
+			c.rateRPS = f
+			c.applyRateLimit()
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "max_rate_burst":
+		if s, ok := value.(int); ok && s >= 0 {
+			c.rateBurst = s
+			c.applyRateLimit()
+		} else {
+			c.fail(fmt.Errorf("%q: expected non-negative integer", option))
+			return
+		}
+	case "auto_touch":
+		if s, ok := value.(bool); ok {
+			c.touch.enabled = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
+			return
+		}
+	case "auto_touch_interval":
+		if d, err := duration(value); err == nil {
+			c.touch.interval = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "auto_touch_max":
+		if d, err := duration(value); err == nil {
+			c.touch.max = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "handler_timeout":
+		if d, err := duration(value); err == nil {
+			c.handlerTimeout = d
+		} else {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+	case "backoff_strategy":
+		if s, ok := value.(string); ok && s != "exponential" && s != "full_jitter" {
+			c.fail(fmt.Errorf("%q: unknown strategy %q, expected \"exponential\" or \"full_jitter\"", option, s))
+			return
+		}
+		if err := c.config.Set(option, value); err != nil {
+			c.fail(fmt.Errorf("%q: %v", option, err))
+		}
+	case "lookupd_http_client":
+		if v, ok := value.(*http.Client); ok && v != nil {
+			c.httpClient = v
+		} else {
+			c.fail(fmt.Errorf("%q: expected *http.Client", option))
+			return
+		}
+	case "max_in_flight_auto":
+		if s, ok := value.(bool); ok {
+			c.maxInFlightAuto = s
+		} else {
+			c.fail(fmt.Errorf("%q: expected boolean", option))
 			return
 		}
 	default:
 		if err := c.config.Set(option, value); err != nil {
-			c.err = err
+			c.fail(fmt.Errorf("%q: %v", option, err))
+			return
+		}
+		if option == "max_in_flight" {
+			c.maxInFlightSet = true
 		}
 	}
 }
 
+// SetBackoffStrategy sets the strategy of go-nsq backoff,
+// e.g. &nsq.FullJitterStrategy{} or a custom one.
+func (c *Consumer) SetBackoffStrategy(s nsq.BackoffStrategy) {
+	c.Set("backoff_strategy", s)
+}
+
+// WithAutoConcurrency makes `concurrency` 0 mean "auto": one handler
+// per GOMAXPROCS. Without it, zero concurrency is a configuration error
+// reported by Start(), since it is most likely a mistake.
+func (c *Consumer) WithAutoConcurrency() *Consumer {
+	c.Set("auto_concurrency", true)
+	return c
+}
+
+// WithLookupdFallbackToNSQD turns the configured nsqd addresses into a fallback
+// for the nsqlookupd ones.
+//
+// When both lists are given, the consumer connects only via nsqlookupd. If none
+// of the nsqlookupds answer at start, it connects directly to the nsqds instead
+// of failing. The nsqlookupds are still registered in this case, so messages
+// from the producers they know about will be received again once they recover.
+func (c *Consumer) WithLookupdFallbackToNSQD() *Consumer {
+	c.Set("lookupd_fallback", true)
+	return c
+}
+
+// WithMaxConcurrentHandlers sets a hard ceiling on the number of handler
+// functions running at the same time, independent of `concurrency` and
+// `max_in_flight`.
+//
+// This allows to keep NSQ delivery concurrency high for throughput while
+// protecting a limited resource used by the handler. The limit is applied
+// by the handler pool workers once they have taken a message: a message over
+// the limit keeps its worker waiting for a free slot, so the messages behind
+// it wait for a worker (or in their shard queue in the sharded mode).
+// Both waits count against msg_timeout. Zero means no limit.
+func (c *Consumer) WithMaxConcurrentHandlers(n int) *Consumer {
+	c.Set("max_concurrent_handlers", n)
+	return c
+}
+
+// WithValidator sets a validator that checks each message body
+// before the handler runs.
+//
+// Messages that fail validation are dropped: the validation error is logged
+// and the message is finished without calling the handler, since redelivering
+// a malformed message would never succeed.
+func (c *Consumer) WithValidator(v Validator) *Consumer {
+	c.Set("validator", v)
+	return c
+}
+
+// WithLookupdHTTPClient replaces the HTTP client used to query nsqlookupds,
+// e.g. to send the queries through a proxy.
+func (c *Consumer) WithLookupdHTTPClient(client *http.Client) *Consumer {
+	c.Set("lookupd_http_client", client)
+	return c
+}
+
+// WithMessageAgeBuckets overrides the upper bounds of the message age histogram.
+// The bounds must be in increasing order.
+func (c *Consumer) WithMessageAgeBuckets(buckets ...time.Duration) *Consumer {
+	c.Set("message_age_buckets", buckets)
+	return c
+}
+
+// MessageAge returns a snapshot of the message age histogram.
+//
+// The age of a message is the time elapsed between its publication
+// (msg.Timestamp) and the moment the handler started processing it,
+// so it reflects end-to-end latency including the time spent in the queue.
+// Clock skew between nsqd and this host affects the result.
+func (c *Consumer) MessageAge() HistogramSnapshot {
+	return c.msgAge.snapshot()
+}
+
+// HandlerDuration returns a snapshot of the time spent in the handler
+// (including middlewares) per message, regardless of its result.
+func (c *Consumer) HandlerDuration() HistogramSnapshot {
+	return c.handlerDur.snapshot()
+}
+
 // Start starts the consumer with a given handler.
 //
-// If there were an error on the configuration step, it will be returned here.
+// If there were an error on the configuration step, it will be returned here
+// (see Validate). Starting a running consumer returns ErrAlreadyStarted.
+//
+// A stopped consumer may be started again: new NSQ Consumers are created
+// from the current configuration, which may be changed between Stop
+// and Start. The callbacks and middlewares are kept; the channel returned
+// by Errors is replaced with a new one.
 func (c *Consumer) Start(handler nsq.Handler) error {
-	if c.err != nil {
-		return c.err
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	return c.start(handler, nil)
+}
+
+// Start is Start called with c.setMu held. If not nil, started is called
+// with c.mu held once the start can no longer fail, before the start
+// hooks are run.
+func (c *Consumer) start(handler nsq.Handler, started func()) error {
+	err := c.validate()
+	if err == nil && isNil(handler) {
+		err = errNilHandler
+	}
+	if err != nil {
+		c.abortStart()
+		return err
 	}
 
-	client, err := nsq.NewConsumer(c.topic, c.channel, c.config)
+	concurrency, _ := c.effectiveConcurrency()
+
+	if c.log != nil && c.level <= nsq.LogLevelDebug {
+		c.logf(nsq.LogLevelDebug, "effective configuration:\n%s", c.dumpConfig())
+	}
+
+	// The lock is held until all connections are initiated,
+	// so that a concurrent Stop() never sees a half-started client.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.state == stateStarted:
+		return ErrAlreadyStarted
+	case c.active():
+		return errStopping
+	case c.state == stateStopped:
+		c.reset()
+	}
+	c.renewTerminated()
+	c.drainExpired.Store(false)
+
+	if err := c.startDiscovery(); err != nil {
+		return err
+	}
+
+	if err := c.startDeadLetter(); err != nil {
+		return err
+	}
+
+	c.syncMaxInFlight(concurrency)
+
+	c.peers.Store(c.newPeerSet())
+	h := c.wrap(handler)
+	c.pool = newPool(c, concurrency)
+
+	for _, topic := range c.allTopics() {
+		client, err := c.newClient(topic, h)
+		if err == nil {
+			c.clients = append(c.clients, client)
+			err = c.connect(client)
+		}
+		if err != nil {
+			c.teardown()
+			if len(c.topics) > 0 {
+				return fmt.Errorf("topic %q: %v", topic, err)
+			}
+			return err
+		}
+	}
+
+	c.state = stateStarted
+	c.handler = handler
+
+	c.done = make(chan struct{})
+	go func(clients []*nsq.Consumer, p *pool, done chan struct{}) {
+		for _, client := range clients {
+			<-client.StopChan
+		}
+		p.close()
+		close(done)
+	}(c.clients, c.pool, c.done)
+
+	clients, done := c.clients, c.done
+
+	c.background(func() { c.watchReady(clients, done) })
+	if len(c.starvationCbs) > 0 {
+		c.background(func() { c.watchStarvation(clients, done) })
+	}
+	c.background(func() { c.watchErrors(clients, done) })
+	if p := c.peers.Load(); p != nil {
+		c.background(func() { p.watch(c.clock, done) })
+	}
+	if c.discoveryInterval > 0 && c.discovers() {
+		c.background(func() { c.refreshDiscovery(clients, done) })
+	}
+	if started != nil {
+		started()
+	}
+	for _, fn := range c.startHooks {
+		fn := fn
+		c.background(func() { fn(done) })
+	}
+	if c.pushURL != "" && c.pushInterval > 0 {
+		c.background(func() { c.pushLoop(done) })
+	}
+
+	return nil
+}
+
+// NewClient creates an NSQ Consumer of a given topic with its handlers.
+func (c *Consumer) newClient(topic string, h *wrappedHandler) (*nsq.Consumer, error) {
+	client, err := nsq.NewConsumer(topic, c.channel, c.config)
 	if err != nil {
+		return nil, err
+	}
+
+	if p := c.peers.Load(); p != nil {
+		p.setLogger(client, c.log, c.level)
+	} else {
+		client.SetLogger(c.log, c.level)
+	}
+	if c.httpClient != nil {
+		client.SetLookupdHttpClient(c.httpClient)
+	}
+
+	th := *h
+	th.topic = topic
+	client.AddHandler(&dispatcher{h: &th, pool: c.pool})
+
+	if c.paused {
+		client.ChangeMaxInFlight(0)
+	}
+
+	return client, nil
+}
+
+// Teardown stops the clients created by a failed Start().
+func (c *Consumer) teardown() {
+	for _, client := range c.clients {
+		client.Stop()
+		<-client.StopChan
+	}
+	c.clients = nil
+	c.pool.close()
+	c.pool = nil
+	c.stopDeadLetter()
+	if p := c.peers.Load(); p != nil {
+		p.flush()
+	}
+}
+
+// AbortStart forgets the previous run of a stopped consumer whose restart
+// has failed before connecting, so that Stop returns ErrNotStarted rather
+// than the result of that run.
+func (c *Consumer) abortStart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateStopped && !c.active() {
+		c.reset()
+	}
+}
+
+// Reset clears the state left by a previous run before a restart.
+func (c *Consumer) reset() {
+	c.clients = nil
+	c.stopDone = nil
+	c.baseCtx, c.cancelBase = context.WithCancelCause(context.Background())
+	c.asyncErrs.reopen()
+}
+
+// Active reports whether the clients are running or still draining.
+// It must be called with c.mu held.
+func (c *Consumer) active() bool {
+	if c.state == stateStarted {
+		return true
+	}
+	if c.stopDone == nil {
+		return false
+	}
+
+	select {
+	case <-c.stopDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// Restart stops the consumer and starts it again with the same handler
+// and the current configuration, e.g. to apply options changed by Set
+// in the meantime. A stopped consumer is just started.
+//
+// An error of the stop is logged and does not prevent the start.
+// Restart returns ErrNotStarted if the consumer has never been started.
+func (c *Consumer) Restart() error {
+	c.mu.Lock()
+	h := c.handler
+	c.mu.Unlock()
+
+	if h == nil {
+		return ErrNotStarted
+	}
+
+	if err := c.Stop(); err != nil {
+		c.logf(nsq.LogLevelWarning, "restart: %v", err)
+	}
+
+	return c.Start(h)
+}
+
+// StartFunc starts the consumer with a given function as the handler.
+// It behaves exactly like Start().
+func (c *Consumer) StartFunc(fn func(*nsq.Message) error) error {
+	if fn == nil {
+		return errNilHandler
+	}
+	return c.Start(HandlerFunc(fn))
+}
+
+// StartContext starts the consumer with a given handler and blocks
+// until a given context is cancelled, then stops the consumer just like
+// Stop() does.
+//
+// Configuration and connection errors are returned immediately, and if
+// the context is already cancelled, ctx.Err() is returned without connecting.
+// After a shutdown triggered by the cancellation the result of Stop() is
+// returned, which is nil for a clean one. If the consumer is stopped by
+// calling Stop() directly, StartContext returns nil once it has stopped.
+func (c *Consumer) StartContext(ctx context.Context, handler nsq.Handler) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	c.client = client
 
-	client.SetLogger(c.log, c.level)
-	client.AddConcurrentHandlers(handler, c.concurrency)
+	if err := c.Start(handler); err != nil {
+		return err
+	}
 
-	return c.connect()
+	select {
+	case <-ctx.Done():
+		return c.Stop()
+	case <-c.done:
+		return nil
+	}
 }
 
-// Stop initiates a graceful stop of the NSQ Consumer and waiting
+// Stop initiates a graceful stop of the NSQ Consumer(s) and waiting
 // until this process completes.
+//
+// If a Pushgateway is configured, the final metrics are pushed
+// and a push error is returned.
+//
+// If Start() is still connecting, Stop waits for it to finish first.
+// A consumer that has never been started, or whose Start has failed,
+// returns ErrNotStarted, so it is safe to defer Stop before checking
+// the result of Start.
+// A stopped one may be started again (see Start and Restart).
 func (c *Consumer) Stop() error {
-	c.client.Stop()
-	<-c.client.StopChan
-	return nil
+	return c.StopContext(context.Background())
 }
 
-// Connect dials the connection to the specified nsqd(s) or nsqlookupd(s).
-func (c *Consumer) connect() error {
-	if len(c.nsqds) == 0 && len(c.nsqlookupds) == 0 {
-		return fmt.Errorf(`at least one "nsqd" or "nsqlookupd" address must be specified`)
+// StopContext is like Stop but gives up waiting when ctx is done
+// and returns ctx.Err(). In that case the shutdown was not clean:
+// some handlers are still running and their messages will be redelivered
+// by nsqd after msg_timeout. The stop goes on in the background.
+//
+// It is safe to call Stop and StopContext concurrently and more than once:
+// the stop is initiated once, and every call waits for it to complete.
+func (c *Consumer) StopContext(ctx context.Context) error {
+	c.mu.Lock()
+	if c.state == stateStarted {
+		c.state = stateStopped
+		c.stopDone, c.stopErr = make(chan struct{}), new(error)
+		go c.stop(c.clients, c.done, c.stopErr)
+	}
+	stopDone, stopErr, cancel := c.stopDone, c.stopErr, c.cancelBase
+	c.mu.Unlock()
+
+	// Never started, or a restart has failed
+	if stopDone == nil {
+		c.terminate(ErrNotStarted)
+		return ErrNotStarted
+	}
+
+	// Cancel the contexts of the handlers. The cancel func is taken
+	// with the lock, so that the ones of a restart are not cancelled.
+	cancel(ErrShuttingDown)
+
+	select {
+	case <-stopDone:
+		return *stopErr
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
+
+// StopTimeout is like StopContext with a timeout.
+func (c *Consumer) StopTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	return c.StopContext(ctx)
+}
+
+// OnStart registers a function run in the background by every successful
+// Start(), including restarts. It must return once done is closed.
+func (c *Consumer) onStart(fn func(done <-chan struct{})) {
+	c.startHooks = append(c.startHooks, fn)
+}
+
+// Background runs fn in a goroutine that the stop waits for.
+func (c *Consumer) background(fn func()) {
+	c.bg.Add(1)
+	go func() {
+		defer c.bg.Done()
+		fn()
+	}()
+}
+
+// OnStop registers a function called by Stop() right after
+// the NSQ Consumers are told to stop, before they drain.
+func (c *Consumer) onStop(fn func()) {
+	c.stopHooks = append(c.stopHooks, fn)
+}
+
+// Stop stops the clients and waits for them to drain, then stops
+// the rest, stores the result in errp and closes c.stopDone.
+func (c *Consumer) stop(clients []*nsq.Consumer, done <-chan struct{}, errp *error) {
+	for _, client := range clients {
+		client.Stop()
+	}
+	for _, fn := range c.stopHooks {
+		fn()
+	}
+	if c.drainLogInterval > 0 {
+		c.background(func() { c.logDrain(done) })
+	}
+	<-done
+	c.bg.Wait()
+
+	if p := c.peers.Load(); p != nil {
+		p.flush()
+	}
+	c.stopDeadLetter()
+
+	var err error
+	if c.pushURL != "" {
+		err = c.push()
+	}
+	*errp = err
+
+	c.closeErrors()
+	c.terminate(err)
+	close(c.stopDone)
+}
+
+// EffectiveConcurrency returns the number of handlers to run.
+func (c *Consumer) effectiveConcurrency() (int, error) {
+	switch {
+	case c.concurrency > 0:
+		return c.concurrency, nil
+	case c.concurrency == 0 && c.autoConcurrency:
+		return runtime.GOMAXPROCS(0), nil
+	case c.concurrency == 0:
+		return 0, fmt.Errorf(`"concurrency" must be positive (use "auto_concurrency" to treat 0 as auto)`)
+	}
+	return 0, fmt.Errorf(`"concurrency" must be positive`)
+}
+
+// SyncMaxInFlight raises max_in_flight to the concurrency (per nsqd, when
+// connecting to several ones directly) in the `max_in_flight_auto` mode,
+// unless max_in_flight is set explicitly. Otherwise it warns if some
+// handlers would always be idle.
+func (c *Consumer) syncMaxInFlight(concurrency int) {
+	if c.maxInFlightAuto && !c.maxInFlightSet {
+		n := concurrency
+		if len(c.nsqlookupds) == 0 && len(c.nsqds) > 1 {
+			n *= len(c.nsqds)
+		}
+		if c.config.MaxInFlight < n {
+			c.logf(nsq.LogLevelInfo, "raising max_in_flight from %d to %d", c.config.MaxInFlight, n)
+			c.config.MaxInFlight = n
+		}
+		return
+	}
+
+	if concurrency > c.config.MaxInFlight {
+		c.logf(nsq.LogLevelWarning, "concurrency %d exceeds max_in_flight %d, some handlers will be idle (see max_in_flight_auto)",
+			concurrency, c.config.MaxInFlight)
+	}
+}
 
-	if len(c.nsqds) > 0 {
-		err := c.client.ConnectToNSQDs(c.nsqds)
+// LogDrain periodically logs the number of messages still being handled
+// until the consumer is stopped. Nothing is logged when there are none.
+func (c *Consumer) logDrain(done <-chan struct{}) {
+	for {
+		select {
+		case <-c.clock.After(c.drainLogInterval):
+		case <-done:
+			return
+		}
+		if n := c.inFlight.len(); n > 0 {
+			c.logf(nsq.LogLevelInfo, "stopping, %d message(s) still in flight", n)
+		}
+	}
+}
+
+// Fail records a configuration error to be returned by Start().
+func (c *Consumer) fail(err error) {
+	c.errMu.Lock()
+	c.errs = append(c.errs, err)
+	c.errMu.Unlock()
+}
+
+// SetErrors returns all configuration errors joined, in the order
+// they occurred, or nil.
+func (c *Consumer) setErrors() error {
+	return errors.Join(c.configErrors()...)
+}
+
+// ConfigErrors returns a copy of the configuration errors.
+func (c *Consumer) configErrors() []error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	return append([]error(nil), c.errs...)
+}
+
+// Started reports whether the consumer is running, or is still stopping.
+// Set ignores options while it is so, except for the rate limit.
+func (c *Consumer) Started() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.active()
+}
+
+// Connect dials the connection of a given client
+// to the specified nsqd(s) or nsqlookupd(s).
+func (c *Consumer) connect(client *nsq.Consumer) error {
+	nsqds, lookupds := c.connectableNSQDs(), c.connectableLookupds()
+
+	if c.lookupdFallback && len(nsqds) > 0 && len(lookupds) > 0 {
+		return c.connectWithFallback(client, nsqds, lookupds)
+	}
+
+	if len(nsqds) > 0 {
+		err := c.connectNSQDs(client, nsqds)
 		if err != nil {
 			return err
 		}
 	}
 
-	if len(c.nsqlookupds) > 0 {
-		err := c.client.ConnectToNSQLookupds(c.nsqlookupds)
+	if len(lookupds) > 0 {
+		err := client.ConnectToNSQLookupds(lookupds)
 		if err != nil {
 			return err
 		}
@@ -175,6 +1261,75 @@ func (c *Consumer) connect() error {
 	return nil
 }
 
+// ConnectWithFallback connects to the nsqlookupds if at least one of them
+// is reachable, and to the nsqds otherwise.
+func (c *Consumer) connectWithFallback(client *nsq.Consumer, nsqds, lookupds []string) error {
+	if err := pingLookupds(lookupds, c.lookupdClient()); err != nil {
+		c.logf(nsq.LogLevelWarning, "%s, falling back to nsqds %v", err, nsqds)
+
+		if err := c.connectNSQDs(client, nsqds); err != nil {
+			return err
+		}
+	}
+
+	return client.ConnectToNSQLookupds(lookupds)
+}
+
+// Logf writes a line to the consumer logger in the same form as go-nsq does.
+func (c *Consumer) logf(lvl nsq.LogLevel, format string, args ...interface{}) {
+	if c.log == nil || lvl < c.level {
+		return
+	}
+	c.log.Output(2, fmt.Sprintf("%-4s [%s/%s] %s", lvl, c.topicLabel(), c.channel, fmt.Sprintf(format, args...)))
+}
+
+// Duration converts an interface value to time.Duration.
+func duration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	default:
+		return 0, fmt.Errorf("expected duration or string")
+	}
+}
+
+// Deref dereferences a pointer to a plain value (a number, string,
+// boolean or slice). It returns false if such a pointer is nil.
+func deref(value interface{}) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr {
+		return value, true
+	}
+
+	switch v.Type().Elem().Kind() {
+	case reflect.Bool, reflect.String, reflect.Slice, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.IsNil() {
+			return nil, false
+		}
+		return v.Elem().Interface(), true
+	}
+
+	return value, true
+}
+
+// LogMsgf writes a line about a given message to the consumer logger
+// and passes it to the message log hooks.
+func (c *Consumer) logMsgf(m *nsq.Message, lvl nsq.LogLevel, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	c.logf(lvl, "%s", line)
+	c.notifyMsgLog(m, lvl, line)
+}
+
+func (c *Consumer) notifyMsgLog(m *nsq.Message, lvl nsq.LogLevel, line string) {
+	for _, fn := range c.msgLogHooks {
+		fn(m, lvl, line)
+	}
+}
+
 // Split slices an interface value into all substrings separated by comma or space and
 // returns a slice of the substrings.
 func split(value interface{}) ([]string, error) {
@@ -0,0 +1,470 @@
+package consumer_test
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestStopBeforeStart(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	if err := c.Stop(); !errors.Is(err, consumer.ErrNotStarted) {
+		t.Fatalf("got %v, want ErrNotStarted", err)
+	}
+	if err := c.Stop(); !errors.Is(err, consumer.ErrNotStarted) {
+		t.Fatalf("second stop: got %v, want ErrNotStarted", err)
+	}
+}
+
+func TestStopAfterFailedStart(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+
+	// No addresses
+	if err := c.Start(nopHandler); err == nil {
+		t.Fatal("expected a configuration error")
+	}
+	if err := c.Stop(); !errors.Is(err, consumer.ErrNotStarted) {
+		t.Fatalf("got %v, want ErrNotStarted", err)
+	}
+}
+
+func TestStopAfterFailedRestart(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqlookupd", fakeLookupd(t))
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fails validation
+	if err := c.Start(nil); err == nil {
+		t.Fatal("expected an error for a nil handler")
+	}
+	if err := c.Stop(); !errors.Is(err, consumer.ErrNotStarted) {
+		t.Fatalf("got %v, want ErrNotStarted", err)
+	}
+
+	// Fails to connect
+	c.Set("nsqd", "127.0.0.1:1")
+	c.Set("nsqlookupds", []string{})
+	if err := c.Start(nopHandler); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if err := c.Stop(); !errors.Is(err, consumer.ErrNotStarted) {
+		t.Fatalf("got %v, want ErrNotStarted", err)
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	c := startedConsumer(t, nopHandler, nil)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.Stop()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("stop %d: %v", i, err)
+		}
+	}
+	if err := c.Stop(); err != nil {
+		t.Errorf("stop after stop: %v", err)
+	}
+
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done is not closed after Stop")
+	}
+}
+
+func TestStopWaitsForShutdown(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	handling, release := make(chan struct{}), make(chan struct{})
+	if err := c.StartFunc(func(*nsq.Message) error {
+		close(handling)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.publish("1", nil, 1)
+	wait(t, handling, "the message to be handled")
+
+	// Both wait for the message in flight
+	stopped := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { stopped <- c.Stop() }()
+	}
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("stop returned with a message in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("stop: %v", err)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("stop does not return")
+		}
+	}
+}
+
+func TestStopDuringConnect(t *testing.T) {
+	d := newFakeNSQD(t)
+	d.hold = make(chan struct{})
+	c := newNSQDConsumer(t, d)
+
+	started := make(chan error, 1)
+	go func() { started <- c.Start(nopHandler) }()
+
+	select {
+	case <-d.identified:
+	case <-time.After(testTimeout):
+		t.Fatal("no IDENTIFY received")
+	}
+
+	// Start is still waiting for the IDENTIFY response
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Stop() }()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned %v before Start has connected", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(d.hold)
+
+	if err := <-started; err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("stop: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Stop did not return")
+	}
+
+	d.expect("CLS")
+	wait(t, c.Done(), "the consumer to terminate")
+}
+
+func TestSetPointers(t *testing.T) {
+	topic, concurrency, fallback := "payments", 4, true
+	var nilString *string
+	var nilInt *int
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqlookupd", "127.0.0.1:4161")
+	c.Set("max_in_flight", 8)
+	c.SetMap(map[string]interface{}{
+		"topic":            &topic,
+		"concurrency":      &concurrency,
+		"lookupd_fallback": &fallback,
+		"channel":          nilString,
+		"max_in_flight":    nilInt,
+	})
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := c.ConfigMap()
+	for opt, want := range map[string]interface{}{
+		"topic":            "payments",
+		"concurrency":      4,
+		"lookupd_fallback": true,
+		// Nil pointers are skipped
+		"channel":       "billing",
+		"max_in_flight": 8,
+	} {
+		if cfg[opt] != want {
+			t.Errorf("%s = %#v, want %#v", opt, cfg[opt], want)
+		}
+	}
+}
+
+func TestAutoConcurrency(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(3))
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqlookupd", "127.0.0.1:4161")
+	c.Set("concurrency", 0)
+	if err := c.Validate(); err == nil {
+		t.Error("expected concurrency 0 to be rejected without auto_concurrency")
+	}
+
+	c = startedConsumer(t, nopHandler, map[string]interface{}{
+		"concurrency":        0,
+		"auto_concurrency":   true,
+		"max_in_flight_auto": true,
+	})
+
+	if v := c.ConfigMap()["max_in_flight"]; v != 3 {
+		t.Errorf("max_in_flight = %v, want GOMAXPROCS", v)
+	}
+}
+
+func TestSetLoggerNil(t *testing.T) {
+	for name, l := range map[string]interface{ Output(int, string) error }{
+		"nil":       nil,
+		"typed nil": (*log.Logger)(nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := consumer.NewConsumer("orders", "billing")
+			c.SetLogger(l, nsq.LogLevelDebug)
+
+			failing := consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("boom") })
+			m := nsq.NewMessage(nsq.MessageID{'1'}, nil)
+			m.Attempts = 1
+
+			// The handler error is logged
+			if err := c.Deliver(failing, m); err == nil {
+				t.Fatal("expected the handler error")
+			}
+
+			// So are the go-nsq lines
+			c.Set("nsqlookupd", fakeLookupd(t))
+			if err := c.Start(nopHandler); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Stop(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSetImmutableAfterStart(t *testing.T) {
+	l := &lineLogger{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(l, nsq.LogLevelWarning)
+	c.Set("nsqlookupd", fakeLookupd(t))
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	c.Set("topic", "payments")
+	c.Set("channel", "audit")
+	c.Set("max_rate", 5)
+
+	cfg := c.ConfigMap()
+	if cfg["topic"] != "orders" || cfg["channel"] != "billing" {
+		t.Errorf("topic/channel = %v/%v, want them unchanged", cfg["topic"], cfg["channel"])
+	}
+	if cfg["max_rate"] != float64(5) {
+		t.Errorf("max_rate = %v, want it changed at runtime", cfg["max_rate"])
+	}
+	if !l.contains(`cannot set "topic": consumer already started`) {
+		t.Error("the ignored topic is not logged")
+	}
+
+	// The ignored options do not fail a restart
+	if err := c.Restart(); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+}
+
+func TestMaxInFlightAuto(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options map[string]interface{}
+		want    int
+	}{
+		{"off", map[string]interface{}{"concurrency": 4}, 1},
+		{"auto", map[string]interface{}{"concurrency": 4, "max_in_flight_auto": true}, 4},
+		{"higher", map[string]interface{}{"concurrency": 4, "max_in_flight": 10, "max_in_flight_auto": true}, 10},
+		// Explicit settings are never overridden, even the go-nsq default
+		{"explicit", map[string]interface{}{"concurrency": 4, "max_in_flight": 2, "max_in_flight_auto": true}, 2},
+		{"explicit default", map[string]interface{}{"concurrency": 4, "max_in_flight": 1, "max_in_flight_auto": true}, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := startedConsumer(t, nopHandler, tt.options)
+
+			if got := c.ConfigMap()["max_in_flight"]; got != tt.want {
+				t.Errorf("got max_in_flight %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxInFlightAutoPerNSQD(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.SetMap(map[string]interface{}{
+		"nsqds":              []string{newFakeNSQD(t).addr(), newFakeNSQD(t).addr(), newFakeNSQD(t).addr()},
+		"concurrency":        4,
+		"max_in_flight_auto": true,
+	})
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if got := c.ConfigMap()["max_in_flight"]; got != 12 {
+		t.Errorf("got max_in_flight %v, want 12", got)
+	}
+}
+
+func TestMaxInFlightWarning(t *testing.T) {
+	for _, tt := range []struct {
+		options map[string]interface{}
+		warned  bool
+	}{
+		{map[string]interface{}{"concurrency": 4}, true},
+		{map[string]interface{}{"concurrency": 4, "max_in_flight": 4}, false},
+		{map[string]interface{}{"concurrency": 4, "max_in_flight_auto": true}, false},
+	} {
+		l := &lineLogger{}
+
+		c := consumer.NewConsumer("orders", "billing")
+		c.SetLogger(l, nsq.LogLevelWarning)
+		c.Set("nsqlookupd", fakeLookupd(t))
+		c.SetMap(tt.options)
+
+		if err := c.Start(nopHandler); err != nil {
+			t.Fatal(err)
+		}
+		c.Stop()
+
+		if warned := l.contains("some handlers will be idle"); warned != tt.warned {
+			t.Errorf("%v: warned %t, want %t", tt.options, warned, tt.warned)
+		}
+	}
+}
+
+func TestSetCoercesStrings(t *testing.T) {
+	for _, tt := range []struct {
+		option string
+		value  string
+		want   interface{} // nil for an error
+	}{
+		{"concurrency", "8", 8},
+		{"concurrency", "8x", nil},
+		{"concurrency", "", nil},
+		{"max_rate_burst", "20", 20},
+		{"max_in_flight", "16", 16},
+		{"max_in_flight", "16x", nil},
+		{"max_attempts", "10", uint16(10)},
+		{"msg_timeout", "90s", 90 * time.Second},
+		{"max_backoff_duration", "2m", 2 * time.Minute},
+		{"msg_timeout", "soon", nil},
+		{"snappy", "true", true},
+		{"snappy", "yes", nil},
+		{"auto_concurrency", "true", true},
+		{"at_most_once", "0", false},
+		{"sharded", "maybe", nil},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.Set("nsqlookupd", "127.0.0.1:4161")
+		c.Set(tt.option, tt.value)
+
+		err := c.Validate()
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s %q: expected an error", tt.option, tt.value)
+		case tt.want != nil && err != nil:
+			t.Errorf("%s %q: %v", tt.option, tt.value, err)
+		case tt.want != nil:
+			if got := c.ConfigMap()[tt.option]; got != tt.want {
+				t.Errorf("%s %q: got %v (%T), want %v (%T)", tt.option, tt.value, got, got, tt.want, tt.want)
+			}
+		}
+	}
+}
+
+func TestConcurrentSetAndStart(t *testing.T) {
+	lookupd := fakeLookupd(t)
+
+	for i := 0; i < 20; i++ {
+		c := consumer.NewConsumer("orders", "billing")
+		c.SetLogger(nil, nsq.LogLevelError)
+		c.Set("nsqlookupd", lookupd)
+		info := c.InfoHandler()
+
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					c.Set("max_in_flight", j+1)
+					c.SetMap(map[string]interface{}{
+						"concurrency":  j + 1,
+						"msg_timeout":  "30s",
+						"max_rate":     10,
+						"channel":      "billing",
+						"nsqlookupds":  lookupd,
+						"max_attempts": 5,
+					})
+					c.ConfigMap()
+					c.DumpConfig()
+					info.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/debug/nsq", nil))
+					c.Started()
+				}
+			}(j)
+		}
+
+		errs := make(chan error, 2)
+		for j := 0; j < 2; j++ {
+			go func() { errs <- c.Start(nopHandler) }()
+		}
+		wg.Wait()
+
+		// Exactly one of the concurrent starts wins
+		var started int
+		for j := 0; j < 2; j++ {
+			switch err := <-errs; {
+			case err == nil:
+				started++
+			case !errors.Is(err, consumer.ErrAlreadyStarted):
+				t.Fatalf("start: %v", err)
+			}
+		}
+		if started != 1 || !c.Started() {
+			t.Fatalf("%d starts succeeded, started = %t", started, c.Started())
+		}
+
+		c.Stop()
+		if c.Started() {
+			t.Fatal("the consumer is started after Stop")
+		}
+	}
+}
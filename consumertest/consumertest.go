@@ -0,0 +1,116 @@
+// Package consumertest provides utilities for testing handlers
+// built with the consumer package without a running nsqd.
+package consumertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// Delegate records the responses to a message instead of sending them to nsqd.
+type Delegate struct {
+	mu           sync.Mutex
+	finished     bool
+	requeued     bool
+	requeueDelay time.Duration
+	backoff      bool
+	touches      int
+}
+
+// OnFinish implements the nsq.MessageDelegate interface.
+func (d *Delegate) OnFinish(*nsq.Message) {
+	d.mu.Lock()
+	d.finished = true
+	d.mu.Unlock()
+}
+
+// OnRequeue implements the nsq.MessageDelegate interface.
+func (d *Delegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	d.mu.Lock()
+	d.requeued = true
+	d.requeueDelay = delay
+	d.backoff = backoff
+	d.mu.Unlock()
+}
+
+// OnTouch implements the nsq.MessageDelegate interface.
+func (d *Delegate) OnTouch(*nsq.Message) {
+	d.mu.Lock()
+	d.touches++
+	d.mu.Unlock()
+}
+
+// Finished reports whether the message was finished.
+func (d *Delegate) Finished() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.finished
+}
+
+// Requeued reports whether the message was requeued, with which delay
+// (-1 means the default one) and whether with backoff.
+func (d *Delegate) Requeued() (ok bool, delay time.Duration, backoff bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requeued, d.requeueDelay, d.backoff
+}
+
+// Touches returns the number of times the message was touched.
+func (d *Delegate) Touches() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.touches
+}
+
+// NewMessage returns a message with a recording delegate.
+// The id is truncated or padded with zeroes to nsq.MsgIDLength.
+func NewMessage(id, body []byte) *nsq.Message {
+	var mid nsq.MessageID
+	copy(mid[:], id)
+
+	m := nsq.NewMessage(mid, body)
+	m.Delegate = &Delegate{}
+
+	return m
+}
+
+// DelegateOf returns the recording delegate of a message created by NewMessage,
+// or nil for any other message.
+func DelegateOf(m *nsq.Message) *Delegate {
+	d, _ := m.Delegate.(*Delegate)
+	return d
+}
+
+// Deliver passes messages through the consumer handler stack
+// concurrently, see consumer.Consumer.Deliver.
+func Deliver(c *consumer.Consumer, h nsq.Handler, msgs ...*nsq.Message) error {
+	return c.Deliver(h, msgs...)
+}
+
+// Handle creates a message of a given body with a recording delegate,
+// passes it through the consumer handler stack and returns the delegate
+// to assert the response on, along with the handler error.
+func Handle(c *consumer.Consumer, h nsq.Handler, body []byte) (*Delegate, error) {
+	m := NewMessage(nil, body)
+	err := c.Deliver(h, m)
+	return DelegateOf(m), err
+}
+
+// DeliverInOrder passes messages through the consumer handler stack strictly
+// one at a time, waiting for each to be handled before the next, regardless
+// of the configured concurrency. This makes tests asserting the processing
+// order reliable. It is for tests only: nsqd gives no ordering guarantee.
+//
+// It stops at the first handler error and returns it.
+func DeliverInOrder(c *consumer.Consumer, h nsq.Handler, msgs ...*nsq.Message) error {
+	for _, m := range msgs {
+		if err := c.Deliver(h, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
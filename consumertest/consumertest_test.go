@@ -0,0 +1,130 @@
+package consumertest_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestNewMessage(t *testing.T) {
+	m := consumertest.NewMessage([]byte("1"), []byte("body"))
+	if want := (nsq.MessageID{'1'}); m.ID != want {
+		t.Errorf("got id %q, want %q", m.ID, want)
+	}
+	if string(m.Body) != "body" {
+		t.Errorf("got body %q", m.Body)
+	}
+
+	long := consumertest.NewMessage([]byte("0123456789abcdefXYZ"), nil)
+	if string(long.ID[:]) != "0123456789abcdef" {
+		t.Errorf("got id %q, want it truncated", long.ID)
+	}
+
+	if consumertest.DelegateOf(m) == nil {
+		t.Error("the message has no recording delegate")
+	}
+	if consumertest.DelegateOf(nsq.NewMessage(nsq.MessageID{}, nil)) != nil {
+		t.Error("got a recording delegate of a plain message")
+	}
+}
+
+func TestHandleFinish(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	d, err := consumertest.Handle(c, consumer.HandlerFunc(func(m *nsq.Message) error {
+		m.Touch()
+		return nil
+	}), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Finished() {
+		t.Error("the message is not finished")
+	}
+	if ok, _, _ := d.Requeued(); ok {
+		t.Error("the message is requeued")
+	}
+	if n := d.Touches(); n != 1 {
+		t.Errorf("got %d touches, want 1", n)
+	}
+}
+
+func TestHandleRequeue(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+
+	boom := errors.New("boom")
+	d, err := consumertest.Handle(c, consumer.HandlerFunc(func(*nsq.Message) error { return boom }), nil)
+	if !errors.Is(err, boom) {
+		t.Fatalf("got error %v, want %v", err, boom)
+	}
+
+	if d.Finished() {
+		t.Error("a failed message is finished")
+	}
+	if ok, delay, backoff := d.Requeued(); !ok || delay != -1 || !backoff {
+		t.Errorf("got requeued %t with delay %s and backoff %t, want the default delay with backoff", ok, delay, backoff)
+	}
+}
+
+func TestHandleExplicitRequeue(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	d, err := consumertest.Handle(c, consumer.HandlerFunc(func(m *nsq.Message) error {
+		m.RequeueWithoutBackoff(5 * time.Second)
+		return nil
+	}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, delay, backoff := d.Requeued(); !ok || delay != 5*time.Second || backoff {
+		t.Errorf("got requeued %t with delay %s and backoff %t", ok, delay, backoff)
+	}
+	if d.Finished() {
+		t.Error("a requeued message is also finished")
+	}
+}
+
+func TestDeliverStack(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	var middleware atomic.Int32
+	c.Use(func(next nsq.Handler) nsq.Handler {
+		return consumer.HandlerFunc(func(m *nsq.Message) error {
+			middleware.Add(1)
+			return next.HandleMessage(m)
+		})
+	})
+	c.Use(consumer.Dedupe(time.Minute, 10))
+
+	var handled atomic.Int32
+	h := consumer.HandlerFunc(func(*nsq.Message) error {
+		handled.Add(1)
+		return nil
+	})
+
+	first := consumertest.NewMessage([]byte("1"), nil)
+	dup := consumertest.NewMessage([]byte("1"), nil)
+
+	if err := consumertest.DeliverInOrder(c, h, first, dup); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := middleware.Load(); n != 2 {
+		t.Errorf("the middleware handled %d messages, want 2", n)
+	}
+	if n := handled.Load(); n != 1 {
+		t.Errorf("the handler handled %d messages, want 1 without the duplicate", n)
+	}
+	if !consumertest.DelegateOf(first).Finished() || !consumertest.DelegateOf(dup).Finished() {
+		t.Error("the message and its duplicate are not finished")
+	}
+}
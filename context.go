@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+const (
+	// The msg_timeout of nsqd used when it is not set in the config.
+	defaultMsgTimeout = 60 * time.Second
+
+	// DefaultMsgTimeoutMargin is subtracted from msg_timeout to get
+	// the handler deadline when the context is derived from it.
+	DefaultMsgTimeoutMargin = 5 * time.Second
+)
+
+// The contexts of the messages currently being handled
+var contexts sync.Map
+
+// SetContext replaces the context of a message being handled, so that
+// the inner middlewares and the handler get it from Context(). A middleware
+// may use it to attach values, e.g. a tracing span. The new context should
+// be derived from the current one to keep its cancellation.
+func SetContext(m *nsq.Message, ctx context.Context) {
+	if _, ok := contexts.Load(m); ok {
+		contexts.Store(m, ctx)
+	}
+}
+
+// ErrShuttingDown is the cause of the cancellation of handler contexts
+// when the consumer is stopping.
+var ErrShuttingDown = errors.New("consumer is shutting down")
+
+// IsShuttingDown reports whether a given handler context has been cancelled
+// because the consumer is stopping.
+//
+// Stop() cancels the contexts of all running handlers before it waits for
+// them to return. Handlers of long-running jobs are expected to check this
+// (or just watch ctx.Done()), save their progress and return promptly:
+// returning an error requeues the message, so another consumer can continue
+// the job. A handler that ignores the cancellation will still be waited for.
+func IsShuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrShuttingDown)
+}
+
+// Context returns the context of a message passed to the handler.
+// It is cancelled when the consumer is stopping (see IsShuttingDown).
+// It is context.Background() for a message not handled by a Consumer.
+func Context(m *nsq.Message) context.Context {
+	if ctx, ok := contexts.Load(m); ok {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+// ContextHandlerFunc is an adapter to use a function with a context
+// as an nsq.Handler. The context is the one returned by Context().
+type ContextHandlerFunc func(ctx context.Context, m *nsq.Message) error
+
+// HandleMessage implements the nsq.Handler interface.
+func (f ContextHandlerFunc) HandleMessage(m *nsq.Message) error {
+	return f(Context(m), m)
+}
+
+// WithContextFromMsgTimeout sets the deadline of every handler context
+// to the msg_timeout minus a safety margin (DefaultMsgTimeoutMargin,
+// or the `msg_timeout_margin` option), counting from the start of the handler.
+// So handlers can abort before nsqd times the message out and redelivers it.
+//
+// The msg_timeout is taken from the `msg_timeout` option of the config.
+// If it is not set, the nsqd default of 60s is assumed; set the option
+// explicitly if nsqd runs with a different --msg-timeout.
+// A handler that calls msg.Touch() to run longer does not get its deadline
+// extended.
+func (c *Consumer) WithContextFromMsgTimeout() *Consumer {
+	c.ctxFromMsgTimeout = true
+	return c
+}
+
+// HandlerDeadline returns the timeout of a handler derived from msg_timeout.
+func (c *Consumer) handlerDeadline() time.Duration {
+	timeout := c.config.MsgTimeout
+	if timeout == 0 {
+		timeout = defaultMsgTimeout
+	}
+
+	margin := c.msgTimeoutMargin
+	if margin == 0 {
+		margin = DefaultMsgTimeoutMargin
+	}
+	if margin >= timeout {
+		return timeout / 2
+	}
+
+	return timeout - margin
+}
+
+// The context key of the subscription of a message
+type subscriptionKey struct{}
+
+type subscription struct {
+	topic, channel string
+}
+
+// Subscription returns the topic and channel a message being handled
+// was received from, or empty strings for a message not handled
+// by a Consumer. It suits middlewares of multi-topic consumers.
+func Subscription(m *nsq.Message) (topic, channel string) {
+	s, _ := Context(m).Value(subscriptionKey{}).(subscription)
+	return s.topic, s.channel
+}
+
+// The context key of the W3C traceparent of a message
+type traceParentKey struct{}
+
+// WithTraceParent returns a copy of ctx carrying a given W3C traceparent
+// (e.g. "00-<trace id>-<span id>-01"). A tracing middleware sets it
+// in the message context (see SetContext), so that the envelope of
+// a message given up after a handler error refers to the failed attempt.
+func WithTraceParent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceparent)
+}
+
+// TraceParent returns the W3C traceparent carried by ctx, if any.
+func TraceParent(ctx context.Context) string {
+	s, _ := ctx.Value(traceParentKey{}).(string)
+	return s
+}
+
+// NewContext returns the context of a message about to be handled.
+func (c *Consumer) newContext(m *nsq.Message, topic string) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(c.baseCtx, subscriptionKey{}, subscription{topic: topic, channel: c.channel})
+	cancel := context.CancelFunc(func() {})
+	if c.ctxFromMsgTimeout {
+		ctx, cancel = context.WithDeadline(ctx, c.clock.Now().Add(c.handlerDeadline()))
+	}
+
+	contexts.Store(m, ctx)
+
+	return ctx, func() {
+		contexts.Delete(m)
+		cancel()
+	}
+}
@@ -0,0 +1,179 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DefaultDeadLetterRetries is the number of times a failed publish
+// to the dead-letter topic is retried unless the `dead_letter_retries`
+// option is set.
+const DefaultDeadLetterRetries = 3
+
+// Limit of the handler errors remembered for the dead-letter envelopes.
+const maxLastErrors = 4096
+
+// SetDeadLetter makes the consumer republish messages that exceeded
+// max_attempts to a given topic on a given nsqd (host:port of the TCP port)
+// before they are discarded. An empty address means the first of the
+// configured nsqds.
+//
+// The republished body is the envelope of the original message (see Envelope
+// and WithEnvelopeBuilder), including the last handler error if it was
+// returned by this consumer. A failed publish is logged and retried
+// `dead_letter_retries` times with a growing delay, then the message
+// is discarded as it would be without a dead-letter topic.
+//
+// The embedded producer is stopped by Stop() after the consumer drains,
+// every Start() creates a new one.
+func (c *Consumer) SetDeadLetter(topic, nsqdAddr string) {
+	c.Set("dead_letter_topic", topic)
+	if nsqdAddr != "" {
+		c.Set("dead_letter_nsqd", nsqdAddr)
+	}
+}
+
+// DeadLetterAddr returns the nsqd address of the dead-letter producer.
+func (c *Consumer) deadLetterAddr() string {
+	if c.deadLetter.addr != "" {
+		return c.deadLetter.addr
+	}
+	if len(c.nsqds) > 0 {
+		return c.nsqds[0]
+	}
+	return ""
+}
+
+type deadLetter struct {
+	topic   string
+	addr    string
+	retries int
+	errors  lastErrors
+
+	// The producer of the current run, nil when stopped
+	mu       sync.Mutex
+	producer *nsq.Producer
+}
+
+// StartDeadLetter creates a new dead-letter producer, if configured.
+func (c *Consumer) startDeadLetter() error {
+	if c.deadLetter.topic == "" {
+		return nil
+	}
+
+	p, err := nsq.NewProducer(c.deadLetterAddr(), c.config)
+	if err != nil {
+		return fmt.Errorf("dead-letter producer: %v", err)
+	}
+	p.SetLogger(c.log, c.level)
+
+	c.deadLetter.mu.Lock()
+	c.deadLetter.producer = p
+	c.deadLetter.mu.Unlock()
+
+	return nil
+}
+
+// StopDeadLetter stops the producer and forgets it, so that a restart
+// without a dead-letter topic does not publish through a stopped one.
+func (c *Consumer) stopDeadLetter() {
+	c.deadLetter.mu.Lock()
+	p := c.deadLetter.producer
+	c.deadLetter.producer = nil
+	c.deadLetter.mu.Unlock()
+
+	if p != nil {
+		p.Stop()
+	}
+}
+
+// DeadLetterProducer returns the producer of the current run, if any.
+func (c *Consumer) deadLetterProducer() *nsq.Producer {
+	c.deadLetter.mu.Lock()
+	defer c.deadLetter.mu.Unlock()
+
+	return c.deadLetter.producer
+}
+
+// PublishDeadLetter republishes a given message to the dead-letter topic.
+func (c *Consumer) publishDeadLetter(topic string, m *nsq.Message, last lastError) {
+	p := c.deadLetterProducer()
+	if p == nil {
+		return
+	}
+
+	body, err := c.envelope(topic, m, last)
+	if err != nil {
+		c.logMsgf(m, nsq.LogLevelError, "failed to build the dead letter of %s, dropping: %v", MessageInfo(m), err)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := p.Publish(c.deadLetter.topic, body)
+		if err == nil {
+			return
+		}
+		if attempt >= c.deadLetter.retries {
+			c.logMsgf(m, nsq.LogLevelError, "failed to publish the dead letter of %s, dropping: %v", MessageInfo(m), err)
+			c.report(&DeadLetterError{ID: m.ID, Err: err})
+			return
+		}
+
+		c.logMsgf(m, nsq.LogLevelWarning, "failed to publish the dead letter of %s, retrying: %v", MessageInfo(m), err)
+		<-c.clock.After(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// LastError is the last handler error of a message with the traceparent
+// of the attempt that returned it.
+type lastError struct {
+	err         error
+	traceparent string
+}
+
+// LastErrors remembers the last handler error per message ID,
+// the oldest entries are evicted when the limit is reached.
+type lastErrors struct {
+	mu    sync.Mutex
+	errs  map[nsq.MessageID]lastError
+	order []nsq.MessageID
+}
+
+func (e *lastErrors) put(id nsq.MessageID, err lastError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.errs == nil {
+		e.errs = make(map[nsq.MessageID]lastError)
+	}
+	if _, ok := e.errs[id]; !ok {
+		if len(e.order) >= maxLastErrors {
+			delete(e.errs, e.order[0])
+			e.order = e.order[1:]
+		}
+		e.order = append(e.order, id)
+	}
+	e.errs[id] = err
+}
+
+func (e *lastErrors) take(id nsq.MessageID) lastError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	err, ok := e.errs[id]
+	if !ok {
+		return lastError{}
+	}
+	delete(e.errs, id)
+	for i, x := range e.order {
+		if x == id {
+			e.order = append(e.order[:i], e.order[i+1:]...)
+			break
+		}
+	}
+
+	return err
+}
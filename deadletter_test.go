@@ -0,0 +1,65 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestDeadLetterRestart(t *testing.T) {
+	d := newFakeNSQD(t)
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.SetMap(map[string]interface{}{
+		"nsqlookupd":          fakeLookupd(t),
+		"max_attempts":        1,
+		"dead_letter_retries": 0,
+	})
+	c.SetDeadLetter("orders.dead", d.addr())
+
+	h := consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("bad order") })
+
+	giveUp := func(id string) {
+		var msgID nsq.MessageID
+		copy(msgID[:], id)
+		m := nsq.NewMessage(msgID, []byte("payload"))
+		for attempts := uint16(1); attempts <= 2; attempts++ {
+			m.Attempts = attempts
+			c.Deliver(h, m)
+		}
+	}
+
+	if err := c.Start(h); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	giveUp("1")
+	d.expect("PUB orders.dead")
+
+	if err := c.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	giveUp("2")
+	d.expect("PUB orders.dead")
+
+	// Without the topic the producer of the previous run is not used
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("dead_letter_topic", "")
+	if err := c.Start(h); err != nil {
+		t.Fatal(err)
+	}
+	giveUp("3")
+
+	select {
+	case err := <-c.Errors():
+		t.Errorf("got error %v without a dead-letter topic", err)
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+package consumer
+
+import (
+	"encoding/json"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// BadMessagePolicy decides what to do with a message whose body cannot be
+// decoded by StartJSON or a similar helper. It may also divert the message,
+// e.g. store it, before returning the action:
+//
+//   - Finish drops the message;
+//   - Requeue and DefaultAction requeue it like a handler error,
+//     which is rarely useful as the body will not change.
+type BadMessagePolicy func(m *nsq.Message, err error) Action
+
+// SetBadMessagePolicy replaces the default policy, which logs
+// and finishes undecodable messages.
+func (c *Consumer) SetBadMessagePolicy(p BadMessagePolicy) {
+	c.badMessage = p
+}
+
+// HandleBadMessage logs a given message as undecodable and applies the bad
+// message policy. It returns the error to be returned by the handler.
+// It is meant for decoding helpers like StartJSON.
+func (c *Consumer) HandleBadMessage(m *nsq.Message, err error) error {
+	c.logMsgf(m, nsq.LogLevelWarning, "%s has a malformed body: %v", MessageInfo(m), err)
+
+	if c.badMessage == nil || c.badMessage(m, err) == Finish {
+		return nil
+	}
+
+	return err
+}
+
+// StartJSON starts the consumer with a handler that unmarshals each message
+// body as JSON into a new value of type T and passes it to fn.
+// Undecodable messages are handled by the bad message policy
+// (see SetBadMessagePolicy).
+func StartJSON[T any](c *Consumer, fn func(m *nsq.Message, payload T) error) error {
+	return c.Start(HandlerFunc(func(m *nsq.Message) error {
+		var payload T
+		if err := json.Unmarshal(m.Body, &payload); err != nil {
+			return c.HandleBadMessage(m, err)
+		}
+		return fn(m, payload)
+	}))
+}
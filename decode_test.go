@@ -0,0 +1,140 @@
+package consumer_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+type order struct {
+	ID     int            `json:"id"`
+	Fields map[string]int `json:"fields"`
+}
+
+func TestStartJSON(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	payloads := make(chan order, 1)
+	if err := consumer.StartJSON(c, func(_ *nsq.Message, o order) error {
+		payloads <- o
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", []byte(`{"id":7,"fields":{"qty":2}}`), 1)
+	d.expect("FIN")
+
+	if o := <-payloads; o.ID != 7 || o.Fields["qty"] != 2 {
+		t.Errorf("got payload %+v", o)
+	}
+}
+
+func TestStartJSONBrokenPayload(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	called := make(chan struct{}, 1)
+	if err := consumer.StartJSON(c, func(*nsq.Message, order) error {
+		called <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	// Finished by default
+	d.publish("1", []byte(`{"id":`), 1)
+	d.expect("FIN")
+
+	if len(called) != 0 {
+		t.Error("the handler was called with a broken payload")
+	}
+}
+
+func TestStartJSONBadMessagePolicy(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	bad := make(chan string, 1)
+	c.SetBadMessagePolicy(func(m *nsq.Message, err error) consumer.Action {
+		bad <- string(m.Body)
+		return consumer.Requeue
+	})
+
+	if err := consumer.StartJSON(c, func(*nsq.Message, order) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", []byte("not json"), 1)
+	d.expect("REQ")
+
+	if b := <-bad; b != "not json" {
+		t.Errorf("the policy got body %q", b)
+	}
+}
+
+func TestStartJSONConcurrency(t *testing.T) {
+	const n = 4
+
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+	c.Set("concurrency", n)
+	c.Set("max_in_flight", n)
+
+	var (
+		mu       sync.Mutex
+		started  int
+		all      = make(chan struct{})
+		payloads = make(map[int]order)
+	)
+	if err := consumer.StartJSON(c, func(_ *nsq.Message, o order) error {
+		mu.Lock()
+		payloads[o.ID] = o
+		if started++; started == n {
+			close(all)
+		}
+		mu.Unlock()
+
+		// All the handlers hold their payloads at the same time
+		select {
+		case <-all:
+		case <-time.After(testTimeout):
+			return errors.New("handlers did not run concurrently")
+		}
+		mu.Lock()
+		o.Fields["seen"]++
+		mu.Unlock()
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	for i := 0; i < n; i++ {
+		d.publish(fmt.Sprint(i), []byte(fmt.Sprintf(`{"id":%d,"fields":{"k%d":%d}}`, i, i, i)), 1)
+	}
+	for i := 0; i < n; i++ {
+		d.expect("FIN")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		o := payloads[i]
+		if len(o.Fields) != 2 || o.Fields[fmt.Sprint("k", i)] != i || o.Fields["seen"] != 1 {
+			t.Errorf("message %d: got payload %+v", i, o)
+		}
+	}
+}
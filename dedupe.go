@@ -0,0 +1,52 @@
+package consumer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Dedupe returns a middleware finishing duplicates of recently handled
+// messages without calling the handler. A message is a duplicate if one
+// with the same ID has been handled successfully within the window.
+// At most size IDs are remembered, the least recently seen are forgotten first.
+//
+// See NewDeduper for a custom key and the number of suppressed duplicates.
+func Dedupe(window time.Duration, size int) Middleware {
+	return NewDeduper(nil, window, size).Middleware
+}
+
+// Deduper is the state of the deduplication middleware. It is the
+// WithResultCache middleware with an in-memory cache bounded by the number
+// of keys, counting the suppressed duplicates.
+type Deduper struct {
+	mw Middleware
+
+	suppressed atomic.Uint64
+}
+
+// NewDeduper returns a deduper keyed by keyFn (the message ID if nil),
+// remembering at most size keys (at least 1) for a given window.
+// Register it with Use(d.Middleware) or UseNamed.
+func NewDeduper(keyFn func(*nsq.Message) string, window time.Duration, size int) *Deduper {
+	if size < 1 {
+		size = 1
+	}
+
+	d := &Deduper{}
+	d.mw = resultCacheMiddleware(newMemoryResultCache(size), keyFn, window, func() { d.suppressed.Add(1) })
+
+	return d
+}
+
+// Middleware is the deduplication middleware. Only successfully handled
+// messages are recorded, so a failed message is retried as usual.
+func (d *Deduper) Middleware(next nsq.Handler) nsq.Handler {
+	return d.mw(next)
+}
+
+// Suppressed returns the number of duplicates finished without handling.
+func (d *Deduper) Suppressed() uint64 {
+	return d.suppressed.Load()
+}
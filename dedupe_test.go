@@ -0,0 +1,70 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestDeduper(t *testing.T) {
+	d := consumer.NewDeduper(nil, time.Hour, 2)
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(d.Middleware)
+
+	var handled []string
+	fail := true
+	h := consumer.HandlerFunc(func(m *nsq.Message) error {
+		handled = append(handled, string(m.Body))
+		if string(m.Body) == "c" && fail {
+			fail = false
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	for _, id := range []string{
+		"a", "a", // a duplicate
+		"b", "c", // c fails and is not recorded
+		"c", "b", // b is still remembered
+		"a", // a is forgotten, as b and c were seen more recently
+	} {
+		consumertest.DeliverInOrder(c, h, consumertest.NewMessage([]byte(id), []byte(id)))
+	}
+
+	want := []string{"a", "b", "c", "c", "a"}
+	if len(handled) != len(want) {
+		t.Fatalf("handled %v, want %v", handled, want)
+	}
+	for i := range want {
+		if handled[i] != want[i] {
+			t.Fatalf("handled %v, want %v", handled, want)
+		}
+	}
+
+	if n := d.Suppressed(); n != 2 {
+		t.Errorf("suppressed %d duplicates, want 2", n)
+	}
+}
+
+func TestMemoryResultCache(t *testing.T) {
+	r := consumer.NewMemoryResultCache()
+
+	r.Put("a", time.Hour)
+	r.Put("b", -time.Second)
+
+	if !r.Has("a") {
+		t.Error("a is not in the cache")
+	}
+	if r.Has("b") {
+		t.Error("expired b is in the cache")
+	}
+	if r.Has("c") {
+		t.Error("c is in the cache")
+	}
+}
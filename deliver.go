@@ -0,0 +1,118 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Deliver passes messages through the whole handler stack of the consumer
+// (validation, middlewares, limits and the given handler) without any network,
+// and responds to each one just like go-nsq does: finishes it on success
+// and requeues it with the default delay on error.
+//
+// Messages are handled by `concurrency` goroutines, so their order is not
+// preserved for concurrency > 1. Deliver blocks until all of them are handled
+// and returns the handler errors joined together.
+//
+// It is intended for tests: see the consumertest package for messages that
+// record the responses. A message without a delegate, as returned by
+// nsq.NewMessage, gets one discarding the responses.
+func (c *Consumer) Deliver(handler nsq.Handler, msgs ...*nsq.Message) error {
+	if err := c.setErrors(); err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		if m.Delegate == nil {
+			m.Delegate = nopDelegate{}
+		}
+	}
+
+	concurrency, err := c.effectiveConcurrency()
+	if err != nil {
+		return err
+	}
+
+	h := c.wrap(handler)
+
+	queue := make(chan int)
+	errs := make([]error, len(msgs))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				m := msgs[i]
+				orig := m.Delegate
+				errs[i] = c.deliver(h, m)
+				// The caller gets its delegate back, unless the message
+				// is still to be responded to
+				if m.HasResponded() {
+					m.Delegate = orig
+				}
+			}
+		}()
+	}
+
+	for i := range msgs {
+		queue <- i
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Deliver mimics the handler loop of go-nsq for a single message.
+func (c *Consumer) deliver(h *wrappedHandler, m *nsq.Message) error {
+	if max := c.config.MaxAttempts; max > 0 && m.Attempts > max {
+		c.logMsgf(m, nsq.LogLevelWarning, "%s has exceeded max attempts, giving up", MessageInfo(m))
+		c.giveUp(h, m)
+		return nil
+	}
+
+	d := c.track(m)
+
+	start := c.clock.Now()
+	c.observeStarted(h.topic, m)
+
+	err := h.HandleMessage(m)
+
+	if !m.IsAutoResponseDisabled() {
+		if err != nil {
+			m.Requeue(-1)
+		} else {
+			m.Finish()
+		}
+	}
+
+	c.observeDone(h.topic, m, d.outcome.get(), c.clock.Now().Sub(start))
+
+	return err
+}
+
+// GiveUp logs a message exceeded max_attempts as failed and finishes it,
+// notifying the observer and the hooks. go-nsq finishes such a message
+// itself after LogFailedMessage, which is a no-op once it is finished here.
+func (c *Consumer) giveUp(h *wrappedHandler, m *nsq.Message) {
+	c.track(m)
+
+	c.observeStarted(h.topic, m)
+	h.LogFailedMessage(m)
+	m.Finish()
+	c.observeDone(h.topic, m, OutcomeGaveUp, 0)
+}
+
+// NopDelegate discards the responses to a message delivered by Deliver.
+type nopDelegate struct{}
+
+func (nopDelegate) OnFinish(*nsq.Message)                       {}
+func (nopDelegate) OnRequeue(*nsq.Message, time.Duration, bool) {}
+func (nopDelegate) OnTouch(*nsq.Message)                        {}
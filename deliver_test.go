@@ -0,0 +1,40 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestDeliverWithoutDelegate(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	var finished int
+	c.OnMessageFinished(func(*nsq.Message) { finished++ })
+
+	ok := nsq.NewMessage(nsq.MessageID{'1'}, []byte("{}"))
+	failed := nsq.NewMessage(nsq.MessageID{'2'}, []byte("{}"))
+
+	h := consumer.HandlerFunc(func(m *nsq.Message) error {
+		if m.ID == failed.ID {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if err := c.Deliver(h, ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deliver(h, failed); err == nil {
+		t.Fatal("expected the handler error")
+	}
+	if !ok.HasResponded() || !failed.HasResponded() {
+		t.Error("messages are not responded to")
+	}
+	if finished != 1 {
+		t.Errorf("finish hooks called %d times, want 1", finished)
+	}
+}
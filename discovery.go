@@ -0,0 +1,249 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Timeout of a single resolution of the discovered addresses
+const discoveryTimeout = 10 * time.Second
+
+// DNSResolver looks up DNS SRV records. *net.Resolver implements it.
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// SetDNSResolver replaces net.DefaultResolver used to resolve
+// the `nsqd_srv` and `nsqlookupd_srv` records, e.g. with a fake one in tests.
+func (c *Consumer) SetDNSResolver(r DNSResolver) {
+	c.Set("dns_resolver", r)
+}
+
+// Discovered holds the addresses resolved in addition to the configured ones.
+type discovered struct {
+	nsqds    []string
+	lookupds []string
+}
+
+var errNoDiscoveredAddrs = errors.New("resolver returned no addresses")
+
+// Discovers reports whether any addresses are to be discovered.
+func (c *Consumer) discovers() bool {
+	return c.nsqdSRV != "" || c.lookupdSRV != "" || c.resolver != nil
+}
+
+// Discover resolves the SRV records of `nsqd_srv` and `nsqlookupd_srv`
+// and calls the Resolver, if any.
+func (c *Consumer) discover(ctx context.Context) (d discovered, err error) {
+	if c.nsqdSRV != "" {
+		if d.nsqds, err = c.resolveSRV(ctx, c.nsqdSRV); err != nil {
+			return d, err
+		}
+	}
+	if c.lookupdSRV != "" {
+		if d.lookupds, err = c.resolveSRV(ctx, c.lookupdSRV); err != nil {
+			return d, err
+		}
+	}
+
+	if c.resolver != nil {
+		nsqds, lookupds, err := c.resolver.Resolve(ctx)
+		if err != nil {
+			return d, err
+		}
+		if len(nsqds) == 0 && len(lookupds) == 0 {
+			return d, errNoDiscoveredAddrs
+		}
+
+		if nsqds, err = c.normalizeAddrs(nsqds, c.normalizeNSQD); err != nil {
+			return d, fmt.Errorf("nsqd %v", err)
+		}
+		if lookupds, err = c.normalizeAddrs(lookupds, normalizeLookupd); err != nil {
+			return d, fmt.Errorf("nsqlookupd %v", err)
+		}
+
+		d.nsqds = union(d.nsqds, nsqds)
+		d.lookupds = union(d.lookupds, lookupds)
+	}
+
+	return d, nil
+}
+
+func (c *Consumer) resolveSRV(ctx context.Context, name string) ([]string, error) {
+	var r DNSResolver = net.DefaultResolver
+	if c.dnsResolver != nil {
+		r = c.dnsResolver
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("SRV %s: %v", name, err)
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("SRV %s: no records", name)
+	}
+
+	addrs := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+
+	return addrs, nil
+}
+
+// StartDiscovery resolves the discovered addresses at start.
+// It must be called with c.mu held.
+func (c *Consumer) startDiscovery() error {
+	c.discovered = discovered{}
+
+	if !c.discovers() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	d, err := c.discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discovery: %v", err)
+	}
+	c.discovered = d
+
+	return nil
+}
+
+// ConnectableNSQDs returns the configured and the discovered nsqds.
+// It must be called with c.mu held.
+func (c *Consumer) connectableNSQDs() []string {
+	return union(c.nsqds, c.discovered.nsqds)
+}
+
+// ConnectableLookupds returns the configured and the discovered nsqlookupds.
+// It must be called with c.mu held.
+func (c *Consumer) connectableLookupds() []string {
+	return union(c.nsqlookupds, c.discovered.lookupds)
+}
+
+func (c *Consumer) lookupdAddrs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.connectableLookupds()
+}
+
+// RefreshDiscovery re-resolves the discovered addresses every
+// `discovery_interval` and connects the clients to the new ones and
+// disconnects them from the gone ones. Failures, including an empty
+// result of the Resolver, are logged and retried on the next tick,
+// keeping the existing connections.
+func (c *Consumer) refreshDiscovery(clients []*nsq.Consumer, done <-chan struct{}) {
+	for {
+		select {
+		case <-c.clock.After(c.discoveryInterval):
+		case <-done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		d, err := c.discover(ctx)
+		cancel()
+
+		if err != nil {
+			c.logf(nsq.LogLevelWarning, "discovery: %v, retrying in %s", err, c.discoveryInterval)
+			continue
+		}
+
+		c.mu.Lock()
+		old := c.discovered
+		c.discovered = d
+		nsqds := append([]string(nil), c.nsqds...)
+		lookupds := append([]string(nil), c.nsqlookupds...)
+		c.mu.Unlock()
+
+		// The lock is not held while connecting
+		c.applyDiscovered(clients, old.nsqds, d.nsqds, nsqds, nsqdConn)
+		c.applyDiscovered(clients, old.lookupds, d.lookupds, lookupds, lookupdConn)
+	}
+}
+
+type connKind int
+
+const (
+	nsqdConn connKind = iota
+	lookupdConn
+)
+
+// ApplyDiscovered connects the clients to the addresses added since
+// the previous resolution and disconnects them from the removed ones,
+// except for the configured ones.
+func (c *Consumer) applyDiscovered(clients []*nsq.Consumer, old, cur, configured []string, kind connKind) {
+	for _, a := range cur {
+		if contains(old, a) || contains(configured, a) {
+			continue
+		}
+		c.logf(nsq.LogLevelInfo, "discovered %s", a)
+		for _, client := range clients {
+			var err error
+			if kind == nsqdConn {
+				if err = c.connectNSQD(client, a); err == nsq.ErrAlreadyConnected {
+					err = nil
+				}
+			} else {
+				err = client.ConnectToNSQLookupd(a)
+			}
+			if err != nil {
+				c.logf(nsq.LogLevelWarning, "failed to connect to discovered %s: %v", a, err)
+			}
+		}
+	}
+
+	for _, a := range old {
+		if contains(cur, a) || contains(configured, a) {
+			continue
+		}
+		c.logf(nsq.LogLevelInfo, "%s is gone from discovery", a)
+		for _, client := range clients {
+			var err error
+			if kind == nsqdConn {
+				if err = client.DisconnectFromNSQD(a); err == nsq.ErrNotConnected {
+					err = nil
+				}
+			} else {
+				err = client.DisconnectFromNSQLookupd(a)
+			}
+			if err != nil {
+				c.logf(nsq.LogLevelWarning, "failed to disconnect from %s: %v", a, err)
+			}
+		}
+	}
+}
+
+func union(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+
+	res := append([]string(nil), a...)
+	for _, s := range b {
+		if !contains(res, s) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
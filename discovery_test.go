@@ -0,0 +1,74 @@
+package consumer_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// SwitchingResolver resolves to given nsqds, changed with set.
+type switchingResolver struct {
+	mu    sync.Mutex
+	nsqds []string
+}
+
+func (r *switchingResolver) set(nsqds ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nsqds = nsqds
+}
+
+func (r *switchingResolver) Resolve(context.Context) ([]string, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.nsqds, nil, nil
+}
+
+func TestRefreshDiscoveryDoesNotBlockWhileDialing(t *testing.T) {
+	d1 := newFakeNSQD(t)
+	d2 := newFakeNSQD(t)
+	d2.hold = make(chan struct{})
+
+	r := &switchingResolver{nsqds: []string{d1.addr()}}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.SetResolver(r, 10*time.Millisecond)
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	r.set(d1.addr(), d2.addr())
+
+	select {
+	case <-d2.identified:
+	case <-time.After(testTimeout):
+		t.Fatal("the discovered nsqd is not dialed")
+	}
+
+	got := make(chan map[string]interface{}, 1)
+	go func() { got <- c.ConfigMap() }()
+
+	select {
+	case <-got:
+	case <-time.After(testTimeout):
+		t.Fatal("the consumer is locked while dialing")
+	}
+
+	// Stopping while connecting leaves the connection to go-nsq's timeout
+	close(d2.hold)
+
+	deadline := time.Now().Add(testTimeout)
+	for c.Stats().Connections != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the discovered nsqd is not connected to")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package consumer
+
+// Done returns a channel that is closed once the consumer has fully stopped:
+// the NSQ Consumers have drained, the handlers and the background goroutines
+// (batch flushes, pollers, metric pushes) have returned, and the final
+// metrics have been pushed. Handlers abandoned on timeout are not waited for
+// (see SetHandlerTimeout). Stop called on a consumer that has never been
+// started closes it at once.
+//
+// It may be called before Start. A failed Start leaves the channel open.
+// A restart begins a new run with a new channel, so Done should be called
+// again after it.
+func (c *Consumer) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.terminated
+}
+
+// Err returns nil if Done is not yet closed. Otherwise it returns the result
+// of the stop: nil for a clean one, an error of the final metrics push,
+// or ErrNotStarted if the consumer has been stopped without starting.
+func (c *Consumer) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.terminated:
+		return c.termErr
+	default:
+		return nil
+	}
+}
+
+// Terminate closes the channel of Done, once per run.
+func (c *Consumer) terminate(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.terminated:
+	default:
+		c.termErr = err
+		close(c.terminated)
+	}
+}
+
+// RenewTerminated replaces the channel of Done closed by a previous run.
+// It must be called with c.mu held.
+func (c *Consumer) renewTerminated() {
+	select {
+	case <-c.terminated:
+		c.terminated = make(chan struct{})
+		c.termErr = nil
+	default:
+	}
+}
@@ -0,0 +1,113 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// How often Drain checks the number of messages in flight
+const drainPollInterval = 100 * time.Millisecond
+
+// ErrDrainTimeout is matched by the error of Drain (see DrainTimeoutError)
+// if the timeout expired before the consumer was drained and stopped.
+var ErrDrainTimeout = errors.New("drain timed out")
+
+// DrainTimeoutError is returned by Drain if some messages were still
+// in flight when the timeout expired. It matches ErrDrainTimeout.
+type DrainTimeoutError struct {
+	InFlight int64
+}
+
+func (e *DrainTimeoutError) Error() string {
+	return fmt.Sprintf("%v with %d message(s) in flight", ErrDrainTimeout, e.InFlight)
+}
+
+func (e *DrainTimeoutError) Unwrap() error { return ErrDrainTimeout }
+
+// Drain stops receiving new messages by lowering max-in-flight to zero
+// (so nsqd passes them to the other consumers of the channel right away),
+// waits up to a given timeout for the messages in flight to be responded to,
+// and then stops the consumer like StopContext does with the rest
+// of the timeout.
+//
+// It returns nil after a clean drain, and a *DrainTimeoutError with the number
+// of messages left in flight if the timeout expired. In that case the messages
+// not passed to the handler yet are requeued without delay, the handlers
+// still running are cancelled (see IsShuttingDown), and the stop goes on
+// in the background like with StopContext: messages whose handlers return
+// later are responded to as usual. An error of the stop is joined
+// to the result.
+//
+// The consumer is restarted unpaused, unless it was paused before Drain.
+func (c *Consumer) Drain(timeout time.Duration) error {
+	c.mu.Lock()
+	if c.state != stateStarted {
+		c.mu.Unlock()
+		return c.Stop()
+	}
+	wasPaused := c.paused
+	c.mu.Unlock()
+
+	c.pause()
+
+	var err error
+
+	deadline := c.clock.Now().Add(timeout)
+	for {
+		n := c.messagesInFlight()
+		if n <= 0 {
+			break
+		}
+		if !c.clock.Now().Before(deadline) {
+			err = &DrainTimeoutError{InFlight: n}
+			c.logf(nsq.LogLevelWarning, "%v, requeueing the messages not handled yet and stopping anyway", err)
+			c.drainExpired.Store(true)
+			break
+		}
+		<-c.clock.After(drainPollInterval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadline.Sub(c.clock.Now()))
+	defer cancel()
+
+	stopErr := c.StopContext(ctx)
+	if stopErr != nil && stopErr == ctx.Err() {
+		// Drained, but the stop did not complete in time
+		if err == nil {
+			err = &DrainTimeoutError{InFlight: c.messagesInFlight()}
+		}
+		stopErr = nil
+	}
+	err = errors.Join(err, stopErr)
+
+	c.mu.Lock()
+	c.paused = wasPaused
+	c.mu.Unlock()
+
+	return err
+}
+
+// MessagesInFlight returns the number of messages received from nsqd
+// and not responded to yet.
+func (c *Consumer) messagesInFlight() int64 {
+	c.mu.Lock()
+	stats := sumStats(c.clients)
+	c.mu.Unlock()
+
+	return int64(stats.MessagesReceived) - int64(stats.MessagesFinished) - int64(stats.MessagesRequeued)
+}
+
+// RequeuedByDrain requeues a message not passed to the handler yet
+// once the timeout of Drain has expired, so that another consumer
+// gets it right away rather than after msg_timeout.
+func (c *Consumer) requeuedByDrain(m *nsq.Message) bool {
+	if !c.drainExpired.Load() {
+		return false
+	}
+	m.RequeueWithoutBackoff(0)
+	return true
+}
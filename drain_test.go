@@ -0,0 +1,90 @@
+package consumer_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestDrainTimeout(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+	c.SetMap(map[string]interface{}{
+		"concurrency":   2,
+		"max_in_flight": 2,
+	})
+	c.WithMaxConcurrentHandlers(1)
+
+	handling, release := make(chan nsq.MessageID, 2), make(chan struct{})
+	defer close(release)
+	if err := c.StartFunc(func(m *nsq.Message) error {
+		handling <- m.ID
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.publish("2", nil, 1)
+
+	var handled nsq.MessageID
+	select {
+	case handled = <-handling:
+	case <-time.After(testTimeout):
+		t.Fatal("no message is handled")
+	}
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	err := c.Drain(timeout)
+	if elapsed := time.Since(start); elapsed > timeout+testTimeout/10 {
+		t.Errorf("Drain returned in %s with a timeout of %s", elapsed, timeout)
+	}
+
+	var te *consumer.DrainTimeoutError
+	if !errors.Is(err, consumer.ErrDrainTimeout) || !errors.As(err, &te) {
+		t.Fatalf("got error %v, want ErrDrainTimeout", err)
+	}
+	if te.InFlight != 2 {
+		t.Errorf("got %d messages in flight, want 2", te.InFlight)
+	}
+
+	// The message waiting for the busy handler is requeued, not handled
+	release <- struct{}{}
+	req := d.expect("REQ")
+	if id, delay, _ := strings.Cut(strings.TrimPrefix(req, "REQ "), " "); id == string(handled[:]) || delay != "0" {
+		t.Errorf("got %q, want the other message requeued without delay", req)
+	}
+	select {
+	case id := <-handling:
+		t.Errorf("msg %s is handled after the drain timeout", id[:1])
+	default:
+	}
+}
+
+func TestDrainClean(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.expect("FIN")
+
+	if err := c.Drain(testTimeout); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if c.Started() {
+		t.Error("the consumer is still running after Drain")
+	}
+}
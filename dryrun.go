@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DryRunStage describes what happened at a stage of the pipeline.
+type DryRunStage struct {
+	Name string
+	// Whether the message reached the stage and was passed to the next one
+	Entered bool
+	Passed  bool
+	// The body as the stage received it
+	Body []byte
+	// The error returned by the stage, if any
+	Err error
+}
+
+// DryRunResult is the outcome of DryRun.
+type DryRunResult struct {
+	Stages []DryRunStage
+	// Whether the message reached the terminal handler, and with which body
+	Reached bool
+	Body    []byte
+	// The response to nsqd: "finish", "requeue" or "" if there was none
+	Response     string
+	RequeueDelay time.Duration
+}
+
+// DryRun runs a synthetic message with a given body through the whole
+// pipeline of the consumer (decompression, validation, middlewares) and
+// reports what each stage did.
+//
+// The terminal handler is never invoked: it is replaced by a stub that
+// succeeds. No network is used, and the consumer counters are not affected.
+// Middlewares are real though, so their side effects and delays apply.
+func (c *Consumer) DryRun(body []byte) (DryRunResult, error) {
+	var res DryRunResult
+
+	if err := c.setErrors(); err != nil {
+		return res, err
+	}
+
+	d := &recordingDelegate{}
+	m := nsq.NewMessage(nsq.MessageID{}, append([]byte(nil), body...))
+	m.Delegate = d
+
+	stage := func(name string) *DryRunStage {
+		res.Stages = append(res.Stages, DryRunStage{Name: name, Entered: true, Body: m.Body})
+		return &res.Stages[len(res.Stages)-1]
+	}
+
+	// Built-in stages
+	s := stage("gzip")
+	c.dryBody(m, s)
+	if s.Err == nil {
+		s.Passed = true
+		s = stage("validator")
+		if c.validator != nil {
+			s.Err = c.validator.Validate(m.Body)
+		}
+		s.Passed = s.Err == nil
+	}
+	if s.Err != nil {
+		// Such messages are dropped
+		res.Response = "finish"
+		return res, nil
+	}
+
+	// Middlewares, each one preceded by a probe
+	var h nsq.Handler = HandlerFunc(func(m *nsq.Message) error {
+		res.Reached = true
+		res.Body = m.Body
+		return nil
+	})
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.probe(&res, len(res.Stages)+i, c.middlewares[i].name, c.middlewares[i].mw(h))
+	}
+	res.Stages = append(res.Stages, make([]DryRunStage, len(c.middlewares))...)
+
+	err := h.HandleMessage(m)
+
+	// The last stage that was entered has either blocked the message
+	// or returned an error
+	for i := range res.Stages {
+		if i+1 < len(res.Stages) {
+			res.Stages[i].Passed = res.Stages[i].Entered && res.Stages[i+1].Entered
+		} else if res.Stages[i].Entered {
+			res.Stages[i].Passed = res.Reached
+		}
+	}
+
+	switch {
+	case d.finished:
+		res.Response = "finish"
+	case d.requeued:
+		res.Response, res.RequeueDelay = "requeue", d.delay
+	case m.IsAutoResponseDisabled():
+	case err != nil:
+		res.Response, res.RequeueDelay = "requeue", -1
+	default:
+		res.Response = "finish"
+	}
+
+	return res, nil
+}
+
+func (c *Consumer) probe(res *DryRunResult, idx int, name string, next nsq.Handler) nsq.Handler {
+	return HandlerFunc(func(m *nsq.Message) error {
+		res.Stages[idx] = DryRunStage{Name: name, Entered: true, Body: m.Body}
+		err := next.HandleMessage(m)
+		res.Stages[idx].Err = err
+		return err
+	})
+}
+
+// DryBody decompresses the body like decodeBody but without counting it.
+func (c *Consumer) dryBody(m *nsq.Message, s *DryRunStage) {
+	if c.gzip && isGzip(m.Body) {
+		body, err := gunzip(m.Body)
+		if err != nil {
+			s.Err = err
+			return
+		}
+		m.Body = body
+	}
+}
+
+// RecordingDelegate records the responses to a message instead of sending them.
+type recordingDelegate struct {
+	finished bool
+	requeued bool
+	touched  int
+	delay    time.Duration
+}
+
+func (d *recordingDelegate) OnFinish(*nsq.Message) { d.finished = true }
+func (d *recordingDelegate) OnTouch(*nsq.Message)  { d.touched++ }
+
+func (d *recordingDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	d.requeued = true
+	d.delay = delay
+}
@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigMap returns the effective configuration of the consumer keyed
+// by the Set option names: the options of the wrapper, combined from
+// the defaults, Set calls and loaders, and all the nsq.Config fields.
+// Secrets such as auth_secret and the TLS config are redacted.
+func (c *Consumer) ConfigMap() map[string]interface{} {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	return c.configMap()
+}
+
+// ConfigMap is ConfigMap with c.setMu held.
+func (c *Consumer) configMap() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := map[string]interface{}{
+		"topic":                   c.topic,
+		"topics":                  append([]string(nil), c.topics...),
+		"channel":                 c.channel,
+		"nsqds":                   append([]string(nil), c.nsqds...),
+		"nsqlookupds":             append([]string(nil), c.nsqlookupds...),
+		"nsqd_srv":                c.nsqdSRV,
+		"nsqlookupd_srv":          c.lookupdSRV,
+		"discovery_interval":      c.discoveryInterval,
+		"concurrency":             c.concurrency,
+		"auto_concurrency":        c.autoConcurrency,
+		"log_level":               c.level.String(),
+		"max_concurrent_handlers": c.maxHandlers,
+		"max_in_flight_auto":      c.maxInFlightAuto,
+		"max_in_flight_bytes":     c.maxInFlightBytes,
+		"lookupd_fallback":        c.lookupdFallback,
+		"lenient_addresses":       c.lenientAddrs,
+		"at_most_once":            c.atMostOnce,
+		"sharded":                 c.sharded,
+		"shard_queue_size":        c.shardQueueSize,
+		"recover_panics":          c.recoverPanics,
+		"permanent_give_up":       c.permanentGiveUp,
+		"handler_timeout":         c.handlerTimeout,
+		"max_rate":                c.rateRPS,
+		"max_rate_burst":          c.rateBurst,
+		"drain_log_interval":      c.drainLogInterval,
+		"dead_letter_topic":       c.deadLetter.topic,
+		"dead_letter_nsqd":        c.deadLetter.addr,
+		"dead_letter_retries":     c.deadLetter.retries,
+		"tls_cert_file":           c.tlsFiles.cert,
+		"tls_key_file":            c.tlsFiles.key,
+		"tls_ca_file":             c.tlsFiles.ca,
+	}
+
+	v := reflect.ValueOf(c.config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		opt := v.Type().Field(i).Tag.Get("opt")
+		if opt == "" || !v.Field(i).CanInterface() {
+			continue
+		}
+		res[opt] = configValue(opt, v.Field(i))
+	}
+
+	return res
+}
+
+// ConfigValue returns a value of an nsq.Config field to be shown.
+func configValue(opt string, f reflect.Value) interface{} {
+	switch {
+	case opt == "auth_secret" || opt == "tls_config":
+		if f.IsZero() {
+			return f.Interface()
+		}
+		return redacted
+	case f.Kind() == reflect.Interface || f.Kind() == reflect.Ptr:
+		if f.IsNil() {
+			return nil
+		}
+		if s, ok := f.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		return fmt.Sprintf("%T", f.Interface())
+	}
+	return f.Interface()
+}
+
+// DumpConfig returns the effective configuration (see ConfigMap)
+// as "option = value" lines sorted by the option name.
+func (c *Consumer) DumpConfig() string {
+	return formatConfig(c.ConfigMap())
+}
+
+// DumpConfig is DumpConfig with c.setMu held.
+func (c *Consumer) dumpConfig() string {
+	return formatConfig(c.configMap())
+}
+
+func formatConfig(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %v\n", k, m[k])
+	}
+
+	return b.String()
+}
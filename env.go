@@ -0,0 +1,91 @@
+package consumer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of the consumer's own options that may be given to Set as strings.
+// Options of nsq.Config are coerced by go-nsq.
+var (
+	intOptions = map[string]bool{
+		"concurrency":             true,
+		"max_concurrent_handlers": true,
+		"max_in_flight_bytes":     true,
+		"dead_letter_retries":     true,
+		"max_rate_burst":          true,
+		"shard_queue_size":        true,
+		"panic_error_limit":       true,
+	}
+	boolOptions = map[string]bool{
+		"auto_concurrency":   true,
+		"at_most_once":       true,
+		"gzip":               true,
+		"recover_panics":     true,
+		"sharded":            true,
+		"auto_touch":         true,
+		"max_in_flight_auto": true,
+		"lookupd_fallback":   true,
+		"lenient_addresses":  true,
+		"permanent_give_up":  true,
+	}
+)
+
+// FromEnv sets options from the environment variables with a given prefix,
+// e.g. with the prefix "NSQ_":
+//
+//	NSQ_TOPIC=orders         -> topic
+//	NSQ_NSQLOOKUPDS=a:1,b:2  -> nsqlookupds
+//	NSQ_MAX_IN_FLIGHT=16     -> max_in_flight (nsq.Config)
+//
+// The option name is the rest of the variable name in lower case.
+// Empty variables are ignored. Errors of the options themselves,
+// including unknown names, are returned by Start() as with Set.
+func (c *Consumer) FromEnv(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("environment prefix must not be empty")
+	}
+
+	options := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, prefix) || v == "" {
+			continue
+		}
+		if name := strings.ToLower(strings.TrimPrefix(k, prefix)); name != "" {
+			options[name] = v
+		}
+	}
+
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c.Set(k, options[k])
+	}
+
+	return nil
+}
+
+// CoerceString converts a string value of the consumer's own integer or boolean
+// option. Any other value, or one that cannot be parsed, is returned as is,
+// so that Set reports the type error.
+func coerceString(option, s string) interface{} {
+	switch {
+	case intOptions[option]:
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+	case boolOptions[option]:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package consumer
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Envelope is the metadata attached to a message republished by the
+// consumer (e.g. to a dead-letter topic). All republish paths build
+// the published body from an Envelope to keep it consistent.
+//
+// The default builder encodes it as JSON:
+//
+//	{
+//	  "topic": "orders",
+//	  "channel": "billing",
+//	  "id": "0a1b2c3d4e5f6a7b",
+//	  "attempts": 5,
+//	  "timestamp": "2021-03-01T10:00:00.123456789Z",
+//	  "error": "handler error text",
+//	  "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+//	  "body": "<base64 of the original body>"
+//	}
+//
+// "error" is the last handler error of the message, omitted when unknown.
+// "traceparent" is the W3C trace context of the attempt that returned
+// the error, taken from the message context (see WithTraceParent),
+// omitted when the handler was not traced.
+type Envelope struct {
+	Topic       string    `json:"topic"`
+	Channel     string    `json:"channel"`
+	ID          string    `json:"id"`
+	Attempts    uint16    `json:"attempts"`
+	Timestamp   time.Time `json:"timestamp"`
+	Error       string    `json:"error,omitempty"`
+	TraceParent string    `json:"traceparent,omitempty"`
+	Body        []byte    `json:"body"`
+}
+
+// EnvelopeBuilder encodes an envelope into the body of the message to be republished.
+type EnvelopeBuilder func(e *Envelope) ([]byte, error)
+
+// DefaultEnvelopeBuilder encodes an envelope as JSON.
+func DefaultEnvelopeBuilder(e *Envelope) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// WithEnvelopeBuilder replaces the builder used for all republished messages.
+func (c *Consumer) WithEnvelopeBuilder(fn EnvelopeBuilder) *Consumer {
+	c.envelopeBuilder = fn
+	return c
+}
+
+// Envelope builds the body of a republished message of a given topic
+// from the original one and its last handler error, which may be unknown.
+func (c *Consumer) envelope(topic string, m *nsq.Message, last lastError) ([]byte, error) {
+	build := c.envelopeBuilder
+	if build == nil {
+		build = DefaultEnvelopeBuilder
+	}
+
+	return build(c.newEnvelope(topic, m, last))
+}
+
+// NewEnvelope returns the envelope of a message of a given topic.
+func (c *Consumer) newEnvelope(topic string, m *nsq.Message, last lastError) *Envelope {
+	info := MessageInfo(m)
+
+	e := &Envelope{
+		Topic:       topic,
+		Channel:     c.channel,
+		ID:          info.ID,
+		Attempts:    info.Attempts,
+		Timestamp:   info.Timestamp.UTC(),
+		Body:        m.Body,
+		TraceParent: last.traceparent,
+	}
+	if last.err != nil {
+		e.Error = last.err.Error()
+	}
+
+	return e
+}
+
+// RemembersErrors reports whether the last handler errors are needed
+// for the envelopes of given up messages.
+func (c *Consumer) remembersErrors() bool {
+	return c.deadLetterProducer() != nil || c.quarantineDir != ""
+}
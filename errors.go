@@ -0,0 +1,186 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Capacity of the channel returned by Errors
+const errorsBufferSize = 64
+
+// Number of consecutive failed nsqlookupd checks reported as LookupdError
+const lookupdFailureThreshold = 3
+
+// How often the connection count is checked for ConnectionsLostError
+const connPollInterval = time.Second
+
+// DefaultPanicErrorLimit is the number of recovered panics reported
+// as a PanicLimitError unless the `panic_error_limit` option is set.
+const DefaultPanicErrorLimit = 10
+
+// ConnectionsLostError is reported when the last nsqd connection is lost.
+type ConnectionsLostError struct{}
+
+func (*ConnectionsLostError) Error() string { return "all nsqd connections are lost" }
+
+// LookupdError is reported when none of the nsqlookupds has responded
+// to its /ping endpoint several times in a row.
+type LookupdError struct {
+	Failures int
+	Err      error
+}
+
+func (e *LookupdError) Error() string {
+	return fmt.Sprintf("%d consecutive nsqlookupd failures: %v", e.Failures, e.Err)
+}
+
+// DeadLetterError is reported when a message could not be published
+// to the dead-letter topic and is dropped.
+type DeadLetterError struct {
+	ID  nsq.MessageID
+	Err error
+}
+
+func (e *DeadLetterError) Error() string {
+	return fmt.Sprintf("failed to publish the dead letter of msg %s: %v", e.ID, e.Err)
+}
+
+// PanicLimitError is reported each time the number of panics recovered
+// by Recoverer reaches a multiple of `panic_error_limit`.
+type PanicLimitError struct {
+	Count uint64
+}
+
+func (e *PanicLimitError) Error() string {
+	return fmt.Sprintf("%d handler panics recovered", e.Count)
+}
+
+// Errors returns a channel of the problems occurred after Start: one of
+// *ConnectionsLostError, *LookupdError, *DeadLetterError or *PanicLimitError.
+// They are logged as well.
+//
+// The channel is buffered; errors that do not fit are dropped and
+// counted by DroppedErrors, so the consumer never blocks on it.
+// It is closed when the consumer stops; a restarted consumer has a new one.
+func (c *Consumer) Errors() <-chan error {
+	c.asyncErrs.mu.Lock()
+	defer c.asyncErrs.mu.Unlock()
+
+	return c.asyncErrs.ch
+}
+
+// DroppedErrors returns the number of errors dropped since the channel
+// returned by Errors was full.
+func (c *Consumer) DroppedErrors() uint64 {
+	return c.asyncErrs.dropped.Load()
+}
+
+type asyncErrors struct {
+	mu      sync.Mutex
+	ch      chan error
+	closed  bool
+	dropped atomic.Uint64
+	panics  atomic.Uint64
+}
+
+func newAsyncErrors() asyncErrors {
+	return asyncErrors{ch: make(chan error, errorsBufferSize)}
+}
+
+// Report sends an error to the channel without blocking.
+func (c *Consumer) report(err error) {
+	e := &c.asyncErrs
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	select {
+	case e.ch <- err:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Reopen replaces the closed channel on restart.
+func (e *asyncErrors) reopen() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		e.ch = make(chan error, errorsBufferSize)
+		e.closed = false
+	}
+}
+
+func (c *Consumer) closeErrors() {
+	e := &c.asyncErrs
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+}
+
+// CountPanic reports a PanicLimitError if the limit is reached.
+func (c *Consumer) countPanic() {
+	limit := uint64(c.panicErrorLimit)
+	if limit == 0 {
+		limit = DefaultPanicErrorLimit
+	}
+
+	if n := c.asyncErrs.panics.Add(1); n%limit == 0 {
+		c.report(&PanicLimitError{Count: n})
+	}
+}
+
+// WatchErrors polls the connection count and the nsqlookupds for Errors.
+func (c *Consumer) watchErrors(clients []*nsq.Consumer, done <-chan struct{}) {
+	var (
+		connected   bool
+		lookupdFail int
+		lastLookupd time.Time
+	)
+
+	for {
+		select {
+		case <-c.clock.After(connPollInterval):
+		case <-done:
+			return
+		}
+
+		switch n := sumStats(clients).Connections; {
+		case n > 0:
+			connected = true
+		case connected:
+			connected = false
+			c.logf(nsq.LogLevelError, "all nsqd connections are lost")
+			c.report(&ConnectionsLostError{})
+		}
+
+		lookupds := c.lookupdAddrs()
+		if len(lookupds) == 0 || c.clock.Now().Sub(lastLookupd) < c.config.LookupdPollInterval {
+			continue
+		}
+		lastLookupd = c.clock.Now()
+
+		if err := pingLookupds(lookupds, c.lookupdClient()); err != nil {
+			if lookupdFail++; lookupdFail == lookupdFailureThreshold {
+				c.logf(nsq.LogLevelError, "%v", err)
+				c.report(&LookupdError{Failures: lookupdFail, Err: err})
+			}
+		} else {
+			lookupdFail = 0
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of the most recent handler outcomes kept to compute the error rate.
+const errorRateSize = 1024
+
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+type errorRate struct {
+	mu      sync.Mutex
+	ring    [errorRateSize]outcome
+	next    int
+	tripped bool
+
+	threshold float64
+	window    time.Duration
+	cb        func(rate float64)
+}
+
+// Record adds an outcome and returns the current error rate and
+// whether it has just exceeded the threshold.
+func (e *errorRate) record(now time.Time, failed bool) (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.ring[e.next] = outcome{at: now, failed: failed}
+	e.next = (e.next + 1) % errorRateSize
+
+	var total, failures int
+	since := now.Add(-e.window)
+	for _, o := range e.ring {
+		if o.at.IsZero() || o.at.Before(since) {
+			continue
+		}
+		total++
+		if o.failed {
+			failures++
+		}
+	}
+
+	rate := float64(failures) / float64(total)
+
+	switch {
+	case rate > e.threshold && !e.tripped:
+		e.tripped = true
+		return rate, true
+	case rate <= e.threshold:
+		e.tripped = false
+	}
+
+	return rate, false
+}
+
+// OnHighErrorRate registers a callback fired when the share of handler
+// errors among the messages handled within the last window exceeds
+// a given threshold (0.0 - 1.0).
+//
+// The rate is computed over the last 1024 outcomes at most, and only
+// in this process. The callback is fired once when the threshold is
+// crossed and again only after the rate has fallen back below it.
+// It runs in its own goroutine.
+//
+// Each call adds a monitor of its own, so several thresholds may be watched,
+// e.g. to warn at 10% and to page at 50%. It may be called at any time.
+func (c *Consumer) OnHighErrorRate(threshold float64, window time.Duration, cb func(rate float64)) {
+	c.cbMu.Lock()
+	c.errRates = append(c.errRates, &errorRate{
+		threshold: threshold,
+		window:    window,
+		cb:        cb,
+	})
+	c.cbMu.Unlock()
+}
+
+// RecordOutcome records the outcome of a handler in every error rate monitor,
+// firing the callbacks of those that have just tripped.
+func (c *Consumer) recordOutcome(failed bool) {
+	c.cbMu.Lock()
+	monitors := c.errRates
+	c.cbMu.Unlock()
+
+	if len(monitors) == 0 {
+		return
+	}
+
+	now := c.clock.Now()
+	for _, e := range monitors {
+		if rate, tripped := e.record(now, failed); tripped {
+			go e.cb(rate)
+		}
+	}
+}
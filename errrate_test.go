@@ -0,0 +1,89 @@
+package consumer_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+var errBoom = errors.New("boom")
+
+func failingHandler(m *nsq.Message) error {
+	if string(m.Body) == "fail" {
+		return errBoom
+	}
+	return nil
+}
+
+func TestOnHighErrorRateMonitors(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing").WithClock(newFakeClock())
+	c.SetLogger(nil, nsq.LogLevelError)
+
+	warn, page := make(chan float64, 10), make(chan float64, 10)
+	c.OnHighErrorRate(0.1, time.Minute, func(rate float64) { warn <- rate })
+	c.OnHighErrorRate(0.5, time.Minute, func(rate float64) { page <- rate })
+
+	deliver := func(bodies ...string) {
+		t.Helper()
+		for _, b := range bodies {
+			consumertest.Handle(c, consumer.HandlerFunc(failingHandler), []byte(b))
+		}
+	}
+
+	// 1 of 5 failed
+	deliver("ok", "ok", "ok", "ok", "fail")
+	select {
+	case rate := <-warn:
+		if rate != 0.2 {
+			t.Errorf("warned at rate %v, want 0.2", rate)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the 10% monitor has not fired")
+	}
+
+	// 5 of 9 failed
+	deliver("fail", "fail", "fail", "fail")
+	select {
+	case rate := <-page:
+		if rate != 5.0/9 {
+			t.Errorf("paged at rate %v, want 5/9", rate)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the 50% monitor has not fired")
+	}
+
+	// Each fires once until the rate falls back
+	time.Sleep(10 * time.Millisecond)
+	if len(warn) != 0 || len(page) != 0 {
+		t.Errorf("got %d more warnings and %d more pages", len(warn), len(page))
+	}
+}
+
+func TestOnHighErrorRateWhileHandling(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("concurrency", 4)
+
+	msgs := make([]*nsq.Message, 100)
+	for i := range msgs {
+		msgs[i] = consumertest.NewMessage([]byte{byte(i)}, []byte("fail"))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			c.OnHighErrorRate(0.5, time.Minute, func(float64) {})
+		}
+	}()
+
+	consumertest.Deliver(c, consumer.HandlerFunc(failingHandler), msgs...)
+	wg.Wait()
+}
@@ -0,0 +1,9 @@
+package consumer
+
+// Clock is the clock interface, exported for the external tests.
+type Clock = clock
+
+// WithClock replaces the real clock, see withClock.
+func (c *Consumer) WithClock(cl Clock) *Consumer {
+	return c.withClock(cl)
+}
@@ -0,0 +1,88 @@
+package consumer
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// ExpvarMu serializes the check and the publishing, expvar.Publish panics on duplicates.
+var expvarMu sync.Mutex
+
+// Activity holds the counters go-nsq does not keep itself.
+type activity struct {
+	requeuedNoBackoff atomic.Uint64
+	givenUp           atomic.Uint64
+	permanent         atomic.Uint64
+	transient         atomic.Uint64
+	lastMessage       atomic.Int64 // Unix nanoseconds
+}
+
+// ExposeExpvar publishes the consumer counters under /debug/vars
+// as a map named "<prefix>.<topic>.<channel>":
+//
+//	{
+//	  "messages_received": 10,
+//	  "messages_finished": 8,
+//	  "messages_requeued": 2,
+//	  "messages_requeued_without_backoff": 1,
+//	  "messages_given_up": 0,
+//	  "permanent_failures": 0,
+//	  "transient_failures": 2,
+//	  "connections": 2,
+//	  "last_message": "2024-01-02T15:04:05.999999999Z"  // "" before the first one
+//	}
+//
+// The values are computed on each read, so it may be called before Start.
+// Calling it again with the same prefix does nothing. It has to be called
+// after the topic and channel are set.
+//
+// RequeueWithoutBackoff calls made after the handler returns
+// (with the auto-response disabled) are not counted.
+func (c *Consumer) ExposeExpvar(prefix string) {
+	name := fmt.Sprintf("%s.%s.%s", prefix, c.topicLabel(), c.channel)
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		stats := c.Stats()
+
+		var last string
+		if ts := c.activity.lastMessage.Load(); ts != 0 {
+			last = time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+		}
+
+		return map[string]interface{}{
+			"messages_received":                 stats.MessagesReceived,
+			"messages_finished":                 stats.MessagesFinished,
+			"messages_requeued":                 stats.MessagesRequeued,
+			"messages_requeued_without_backoff": c.activity.requeuedNoBackoff.Load(),
+			"messages_given_up":                 c.activity.givenUp.Load(),
+			"permanent_failures":                c.activity.permanent.Load(),
+			"transient_failures":                c.activity.transient.Load(),
+			"connections":                       stats.Connections,
+			"last_message":                      last,
+		}
+	}))
+}
+
+// Track records the message arrival and observes its responses (see
+// observingDelegate). The original delegate is not put back, since
+// the handler may respond once it has returned (e.g. a batch handler).
+func (c *Consumer) track(m *nsq.Message) *observingDelegate {
+	c.activity.lastMessage.Store(c.clock.Now().UnixNano())
+
+	d := &observingDelegate{MessageDelegate: m.Delegate, c: c}
+	m.Delegate = d
+
+	return d
+}
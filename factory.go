@@ -0,0 +1,80 @@
+package consumer
+
+import (
+	"sort"
+
+	"github.com/nsqio/go-nsq"
+)
+
+type optionValue struct {
+	name  string
+	value interface{}
+}
+
+// Factory produces consumers sharing the same defaults: endpoints, TLS,
+// logger, middlewares and any other options. It is handy to provide
+// consumers through a dependency injection framework.
+//
+// Defaults are applied by New() in the order they were set, before
+// the topic and channel passed to New(). Any option set on the returned
+// consumer overrides the factory default, and middlewares registered on it
+// are applied inside the factory ones.
+type Factory struct {
+	options     []optionValue
+	log         logger
+	level       nsq.LogLevel
+	middlewares []Middleware
+}
+
+// NewFactory returns a new factory without defaults.
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+// Set records a default option. See Consumer.Set for the list of options.
+// Errors are reported by Start() of the produced consumers.
+func (f *Factory) Set(option string, value interface{}) {
+	f.options = append(f.options, optionValue{name: option, value: value})
+}
+
+// SetMap records several default options at once.
+func (f *Factory) SetMap(options map[string]interface{}) {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		f.Set(k, options[k])
+	}
+}
+
+// SetLogger sets the default logger.
+func (f *Factory) SetLogger(log logger, level nsq.LogLevel) {
+	f.log = log
+	f.level = level
+}
+
+// Use registers default middlewares.
+func (f *Factory) Use(mw ...Middleware) {
+	f.middlewares = append(f.middlewares, mw...)
+}
+
+// New returns a new consumer of a given topic and channel with all defaults applied.
+func (f *Factory) New(topic, channel string) *Consumer {
+	c := NewConsumer(topic, channel)
+
+	if f.log != nil {
+		c.SetLogger(f.log, f.level)
+	}
+	for _, o := range f.options {
+		c.Set(o.name, o.value)
+	}
+	c.Use(f.middlewares...)
+
+	c.Set("topic", topic)
+	c.Set("channel", channel)
+
+	return c
+}
@@ -0,0 +1,59 @@
+package consumer_test
+
+import (
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestFactoryOverrides(t *testing.T) {
+	f := consumer.NewFactory()
+	f.SetMap(map[string]interface{}{
+		"nsqlookupd":    "127.0.0.1:4161",
+		"concurrency":   4,
+		"max_in_flight": 8,
+		"topic":         "ignored",
+	})
+
+	var order []string
+	mark := func(name string) consumer.Middleware {
+		return func(next nsq.Handler) nsq.Handler {
+			return consumer.HandlerFunc(func(m *nsq.Message) error {
+				order = append(order, name)
+				return next.HandleMessage(m)
+			})
+		}
+	}
+	f.Use(mark("factory"))
+
+	c := f.New("orders", "billing")
+	c.Set("concurrency", 2)
+	c.Use(mark("consumer"))
+
+	cfg := c.ConfigMap()
+	for opt, want := range map[string]interface{}{
+		"topic":         "orders",
+		"channel":       "billing",
+		"concurrency":   2,
+		"max_in_flight": 8,
+	} {
+		if cfg[opt] != want {
+			t.Errorf("%s = %#v, want %#v", opt, cfg[opt], want)
+		}
+	}
+
+	if _, err := consumertest.Handle(c, nopHandler, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != "factory" || order[1] != "consumer" {
+		t.Errorf("middlewares applied in order %v, want [factory consumer]", order)
+	}
+
+	// The defaults of another consumer are not affected
+	if v := f.New("payments", "billing").ConfigMap()["concurrency"]; v != 4 {
+		t.Errorf("concurrency of another consumer = %v, want 4", v)
+	}
+}
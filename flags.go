@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// RegisterFlags defines command line flags for the main options on fs,
+// named with a given prefix (e.g. "nsq-"):
+//
+//	-nsq-topic          topic
+//	-nsq-channel        channel
+//	-nsq-nsqd           nsqd address, may be repeated or separated by comma
+//	-nsq-lookupd        nsqlookupd address, may be repeated or separated by comma
+//	-nsq-concurrency    concurrency
+//	-nsq-max-in-flight  max_in_flight
+//	-nsq-log-level      debug, info, warning or error
+//
+// The values are passed to Set as they are parsed, so errors are returned
+// by Start(). The defaults are the current values of the consumer,
+// so it should be called after FromEnv, SetMap etc.
+func (c *Consumer) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.Var(&stringFlag{c: c, option: "topic", value: c.topic}, prefix+"topic", "NSQ topic")
+	fs.Var(&stringFlag{c: c, option: "channel", value: c.channel}, prefix+"channel", "NSQ channel")
+	fs.Var(&listFlag{c: c, option: "nsqds", values: c.nsqds}, prefix+"nsqd", "nsqd address (may be repeated)")
+	fs.Var(&listFlag{c: c, option: "nsqlookupds", values: c.nsqlookupds}, prefix+"lookupd", "nsqlookupd address (may be repeated)")
+	fs.Var(&intFlag{c: c, option: "concurrency", value: c.concurrency}, prefix+"concurrency", "number of concurrent handlers")
+	fs.Var(&intFlag{c: c, option: "max_in_flight", value: c.config.MaxInFlight}, prefix+"max-in-flight", "maximum number of messages in flight")
+	fs.Var(&levelFlag{c: c}, prefix+"log-level", "log level: debug, info, warning or error")
+}
+
+type stringFlag struct {
+	c      *Consumer
+	option string
+	value  string
+}
+
+func (f *stringFlag) String() string { return f.value }
+
+func (f *stringFlag) Set(s string) error {
+	f.value = s
+	f.c.Set(f.option, s)
+	return nil
+}
+
+type intFlag struct {
+	c      *Consumer
+	option string
+	value  int
+}
+
+func (f *intFlag) String() string { return strconv.Itoa(f.value) }
+
+func (f *intFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("expected integer")
+	}
+	f.value = n
+	f.c.Set(f.option, n)
+	return nil
+}
+
+// ListFlag accumulates repeated flags. The first one replaces the default list.
+type listFlag struct {
+	c      *Consumer
+	option string
+	values []string
+	set    bool
+}
+
+func (f *listFlag) String() string { return strings.Join(f.values, ",") }
+
+func (f *listFlag) Set(s string) error {
+	values, _ := split(s)
+	if !f.set {
+		f.values = nil
+		f.set = true
+	}
+	f.values = append(f.values, values...)
+	f.c.Set(f.option, f.values)
+	return nil
+}
+
+var logLevels = map[string]nsq.LogLevel{
+	"debug":   nsq.LogLevelDebug,
+	"info":    nsq.LogLevelInfo,
+	"warning": nsq.LogLevelWarning,
+	"error":   nsq.LogLevelError,
+}
+
+type levelFlag struct {
+	c *Consumer
+}
+
+func (f *levelFlag) String() string {
+	if f.c == nil {
+		return ""
+	}
+
+	f.c.mu.Lock()
+	level := f.c.level
+	f.c.mu.Unlock()
+
+	for name, lvl := range logLevels {
+		if lvl == level {
+			return name
+		}
+	}
+	return ""
+}
+
+func (f *levelFlag) Set(s string) error {
+	lvl, ok := logLevels[strings.ToLower(s)]
+	if !ok {
+		return fmt.Errorf("expected debug, info, warning or error")
+	}
+
+	f.c.mu.Lock()
+	f.c.level = lvl
+	f.c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package consumer_test
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestRegisterFlags(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c.RegisterFlags(fs, "nsq-")
+
+	err := fs.Parse([]string{
+		"-nsq-topic", "payments",
+		"-nsq-lookupd", "127.0.0.1:4161",
+		"-nsq-lookupd", "127.0.0.2:4161",
+		"-nsq-concurrency", "4",
+		"-nsq-max-in-flight", "8",
+		"-nsq-log-level", "warning",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := c.ConfigMap()
+	for opt, want := range map[string]interface{}{
+		"topic":         "payments",
+		"nsqlookupds":   []string{"127.0.0.1:4161", "127.0.0.2:4161"},
+		"concurrency":   4,
+		"max_in_flight": 8,
+		"log_level":     "WRN",
+	} {
+		if !reflect.DeepEqual(cfg[opt], want) {
+			t.Errorf("%s = %#v, want %#v", opt, cfg[opt], want)
+		}
+	}
+
+	if err := fs.Parse([]string{"-nsq-log-level", "verbose"}); err == nil {
+		t.Error("expected an unknown log level to be rejected")
+	}
+}
+
+func TestLogLevelFlagConcurrently(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.RegisterFlags(fs, "")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.ConfigMap()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		fs.Set("log-level", "debug")
+	}
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+)
+
+type member struct {
+	c *Consumer
+	h nsq.Handler
+}
+
+// Group manages several consumers with their own handlers together.
+//
+// Members may consume different topics, or different channels of the same
+// topic (e.g. for independent processing pipelines). In the latter case each
+// channel receives its own copy of every message published to the topic,
+// as NSQ fans messages out to all channels, and each member has its own
+// connections, concurrency and max-in-flight, so the pipelines do not
+// interfere. Two members with the same topic and channel would split
+// the messages of the channel between them instead, so this is an error.
+type Group struct {
+	members []member
+	err     error
+}
+
+// Add adds a consumer and its handler to the group.
+// Any error will be returned by Start().
+func (g *Group) Add(c *Consumer, h nsq.Handler) {
+	for _, m := range g.members {
+		if m.c.topic == c.topic && m.c.channel == c.channel {
+			g.err = fmt.Errorf("%s/%s: duplicate topic and channel in the group", c.topic, c.channel)
+			return
+		}
+	}
+	g.members = append(g.members, member{c: c, h: h})
+}
+
+// Start starts all members of the group. If any of them fails,
+// already started ones are stopped and the error is returned.
+func (g *Group) Start() error {
+	if g.err != nil {
+		return g.err
+	}
+
+	for i, m := range g.members {
+		if err := m.c.Start(m.h); err != nil {
+			for _, started := range g.members[:i] {
+				started.c.Stop()
+			}
+			return fmt.Errorf("%s/%s: %v", m.c.topic, m.c.channel, err)
+		}
+	}
+
+	return nil
+}
+
+// Stop stops all members of the group concurrently and waits
+// until all of them complete. The first error is returned.
+func (g *Group) Stop() error {
+	errs := make([]error, len(g.members))
+
+	var wg sync.WaitGroup
+
+	for i, m := range g.members {
+		wg.Add(1)
+		go func(i int, c *Consumer) {
+			defer wg.Done()
+			if err := c.Stop(); err != nil {
+				errs[i] = fmt.Errorf("%s/%s: %v", c.topic, c.channel, err)
+			}
+		}(i, m.c)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run starts all members of the group and blocks until a given context
+// is cancelled or any member stops on its own (e.g. it was stopped directly),
+// then stops all of them like Stop does.
+//
+// The errors of Start are returned immediately. Otherwise the result
+// of Stop is returned, joined with an error naming the member that stopped
+// on its own, if any.
+func (g *Group) Run(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := g.Start(); err != nil {
+		return err
+	}
+
+	stopped := make(chan *Consumer, len(g.members))
+	for _, m := range g.members {
+		go func(c *Consumer, done <-chan struct{}) {
+			select {
+			case <-done:
+				stopped <- c
+			case <-ctx.Done():
+			}
+		}(m.c, m.c.done)
+	}
+
+	var failure error
+
+	select {
+	case <-ctx.Done():
+	case c := <-stopped:
+		failure = fmt.Errorf("%s/%s: stopped unexpectedly", c.topic, c.channel)
+	}
+
+	return errors.Join(failure, g.Stop())
+}
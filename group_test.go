@@ -0,0 +1,52 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestGroupChannelsOfOneTopic(t *testing.T) {
+	da, db := newFakeNSQD(t), newFakeNSQD(t)
+
+	a := consumer.NewConsumer("orders", "billing")
+	a.SetLogger(nil, nsq.LogLevelError)
+	a.Set("nsqd", da.addr())
+
+	b := consumer.NewConsumer("orders", "audit")
+	b.SetLogger(nil, nsq.LogLevelError)
+	b.Set("nsqd", db.addr())
+	b.Set("concurrency", 4)
+
+	var g consumer.Group
+	g.Add(a, consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("boom") }))
+	g.Add(b, nopHandler)
+
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+
+	da.expect("SUB orders billing")
+	db.expect("SUB orders audit")
+
+	// The copies of a message are handled independently
+	da.publish("1", []byte("{}"), 1)
+	db.publish("1", []byte("{}"), 1)
+
+	da.expect("REQ")
+	db.expect("FIN")
+}
+
+func TestGroupDuplicateChannel(t *testing.T) {
+	var g consumer.Group
+	g.Add(consumer.NewConsumer("orders", "billing"), nopHandler)
+	g.Add(consumer.NewConsumer("orders", "billing"), nopHandler)
+
+	if err := g.Start(); err == nil {
+		t.Fatal("expected a duplicate topic and channel to be rejected")
+	}
+}
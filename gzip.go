@@ -0,0 +1,98 @@
+package consumer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync/atomic"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// BodyStats are the counters of compressed and uncompressed
+// message bodies processed by the consumer.
+type BodyStats struct {
+	// Messages with a gzip body and their total size
+	CompressedMessages uint64
+	CompressedBytes    uint64
+
+	// Total size of the gzip bodies after decompression.
+	// Only counted when the `gzip` option is enabled.
+	DecompressedBytes uint64
+
+	// Messages with a plain body and their total size
+	UncompressedMessages uint64
+	UncompressedBytes    uint64
+}
+
+type bodyCounters struct {
+	compressedMessages   uint64
+	compressedBytes      uint64
+	decompressedBytes    uint64
+	uncompressedMessages uint64
+	uncompressedBytes    uint64
+}
+
+// WithGzip enables transparent decompression of gzip message bodies.
+// Bodies are recognized by the gzip magic number, so plain bodies
+// on the same topic are passed to the handler as is.
+func (c *Consumer) WithGzip() *Consumer {
+	c.Set("gzip", true)
+	return c
+}
+
+// BodyStats returns the counters of compressed and uncompressed bodies.
+// Gzip bodies are detected and counted even if decompression is disabled,
+// which helps to estimate the adoption of compression by producers.
+func (c *Consumer) BodyStats() BodyStats {
+	b := &c.bodyCounters
+	return BodyStats{
+		CompressedMessages:   atomic.LoadUint64(&b.compressedMessages),
+		CompressedBytes:      atomic.LoadUint64(&b.compressedBytes),
+		DecompressedBytes:    atomic.LoadUint64(&b.decompressedBytes),
+		UncompressedMessages: atomic.LoadUint64(&b.uncompressedMessages),
+		UncompressedBytes:    atomic.LoadUint64(&b.uncompressedBytes),
+	}
+}
+
+func isGzip(body []byte) bool {
+	return len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b
+}
+
+// DecodeBody counts the message body and decompresses it if needed.
+func (c *Consumer) decodeBody(m *nsq.Message) error {
+	b := &c.bodyCounters
+
+	if !isGzip(m.Body) {
+		atomic.AddUint64(&b.uncompressedMessages, 1)
+		atomic.AddUint64(&b.uncompressedBytes, uint64(len(m.Body)))
+		return nil
+	}
+
+	atomic.AddUint64(&b.compressedMessages, 1)
+	atomic.AddUint64(&b.compressedBytes, uint64(len(m.Body)))
+
+	if !c.gzip {
+		return nil
+	}
+
+	body, err := gunzip(m.Body)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&b.decompressedBytes, uint64(len(body)))
+	m.Body = body
+
+	return nil
+}
+
+func gunzip(body []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
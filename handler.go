@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"github.com/nsqio/go-nsq"
+)
+
+// HandlerFunc is an adapter to use an ordinary function as an nsq.Handler.
+type HandlerFunc func(m *nsq.Message) error
+
+// HandleMessage implements the nsq.Handler interface.
+func (f HandlerFunc) HandleMessage(m *nsq.Message) error {
+	return f(m)
+}
+
+// Validator is the interface that checks a message body
+// before it is passed to the handler.
+type Validator interface {
+	Validate(body []byte) error
+}
+
+// WrappedHandler wraps the user handler and does all the accounting
+// the consumer needs around each message.
+type wrappedHandler struct {
+	c    *Consumer
+	next nsq.Handler
+	user nsq.Handler
+	sem  chan struct{}
+
+	// Topic is set per client, the other fields are shared by all topics.
+	topic string
+}
+
+func (c *Consumer) wrap(handler nsq.Handler) *wrappedHandler {
+	h := &wrappedHandler{
+		c:     c,
+		next:  c.chain(c.countHandled(handler)),
+		user:  handler,
+		topic: c.topic,
+	}
+	if c.recoverPanics {
+		h.next = Recoverer()(h.next)
+	}
+
+	if c.maxHandlers > 0 {
+		h.sem = make(chan struct{}, c.maxHandlers)
+	}
+
+	return h
+}
+
+func (h *wrappedHandler) HandleMessage(m *nsq.Message) error {
+	releaseBytes, ok := h.c.acquireBytes(m)
+	if !ok {
+		return nil
+	}
+	defer releaseBytes()
+
+	if err := h.c.decodeBody(m); err != nil {
+		h.c.logMsgf(m, nsq.LogLevelWarning, "%s has a broken gzip body, dropping: %v", MessageInfo(m), err)
+		return nil
+	}
+
+	h.c.warnAttempts(m)
+
+	h.c.msgAge.observe(h.c.clock.Now().Sub(MessageInfo(m).Timestamp))
+
+	if v := h.c.validator; v != nil {
+		if err := v.Validate(m.Body); err != nil {
+			h.c.logMsgf(m, nsq.LogLevelWarning, "%s is invalid, dropping: %v", MessageInfo(m), err)
+			return nil
+		}
+	}
+
+	release, ok := h.limited(m)
+	if !ok {
+		return nil
+	}
+	defer release()
+
+	if h.c.atMostOnce {
+		m.DisableAutoResponse()
+		m.Finish()
+	}
+
+	ctx, cancel := h.c.newContext(m, h.topic)
+	defer cancel()
+
+	h.c.inFlight.add(m, h.c.clock.Now())
+	defer h.c.inFlight.remove(m)
+
+	if h.sem != nil {
+		h.sem <- struct{}{}
+		defer func() { <-h.sem }()
+	}
+
+	if h.c.requeuedByDrain(m) {
+		return nil
+	}
+
+	if !h.rateLimited(ctx, m) {
+		return nil
+	}
+
+	stopTouch := h.c.autoTouch(m)
+	start := h.c.clock.Now()
+	err := h.callNext(m)
+	stopTouch()
+	h.c.handlerDur.observe(h.c.clock.Now().Sub(start))
+	if err != nil {
+		h.c.reportPanic(m, err)
+		// go-nsq logs handler errors itself
+		h.c.notifyMsgLog(m, nsq.LogLevelError, "handler returned error: "+err.Error())
+		if h.c.remembersErrors() {
+			h.c.deadLetter.errors.put(m.ID, lastError{err: err, traceparent: TraceParent(Context(m))})
+		}
+		h.respond(m, err)
+	}
+
+	h.c.recordOutcome(err != nil)
+
+	return err
+}
+
+// LogFailedMessage is called by go-nsq for a message that exceeded
+// max_attempts. The message is passed to the OnGiveUp callbacks, quarantined
+// and republished to the dead-letter topic if configured, then passed
+// to the user handler if it implements the nsq.FailedMessageLogger interface.
+func (h *wrappedHandler) LogFailedMessage(m *nsq.Message) {
+	h.c.activity.givenUp.Add(1)
+	markGivenUp(m)
+	for _, fn := range h.c.giveUpCbs {
+		h.c.callHook(m, func() { fn(m) })
+	}
+
+	var last lastError
+	if h.c.remembersErrors() {
+		last = h.c.deadLetter.errors.take(m.ID)
+	}
+	h.c.quarantine(h.topic, m, last)
+	h.c.publishDeadLetter(h.topic, m, last)
+
+	if l, ok := h.user.(nsq.FailedMessageLogger); ok {
+		l.LogFailedMessage(m)
+	}
+}
@@ -0,0 +1,92 @@
+package consumer_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestMaxConcurrentHandlers(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing").WithMaxConcurrentHandlers(2)
+	c.Set("concurrency", 8)
+
+	var running, peak atomic.Int32
+	h := consumer.HandlerFunc(func(*nsq.Message) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		return nil
+	})
+
+	msgs := make([]*nsq.Message, 20)
+	for i := range msgs {
+		msgs[i] = consumertest.NewMessage([]byte{byte(i)}, nil)
+	}
+
+	if err := consumertest.Deliver(c, h, msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := peak.Load(); p != 2 {
+		t.Errorf("%d handlers ran at the same time, want 2", p)
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	handled := make(chan struct{})
+	if err := c.Start(consumer.HandlerFunc(func(*nsq.Message) error {
+		close(handled)
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	wait(t, handled, "the message to be handled")
+	d.expect("FIN")
+}
+
+func TestStartFunc(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	if err := c.StartFunc(nil); err == nil {
+		t.Fatal("expected a nil function to be rejected")
+	}
+
+	if err := c.StartFunc(func(*nsq.Message) error { return errors.New("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.expect("REQ")
+}
+
+func TestStartFuncConfigErrors(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("nsqlookupd", "127.0.0.1:4161")
+	c.Set("max_in_flight", "many")
+
+	if err := c.StartFunc(func(*nsq.Message) error { return nil }); err == nil {
+		t.Fatal("expected the deferred configuration error")
+	}
+}
@@ -0,0 +1,53 @@
+package consumer
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Timeout of the nsqlookupd requests made by Ping
+const pingTimeout = 2 * time.Second
+
+var errUnhealthy = errors.New("consumer is not connected to any nsqd")
+
+// IsHealthy reports whether the consumer is running and connected
+// to at least one nsqd (for each of its topics).
+func (c *Consumer) IsHealthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state == stateStarted && allConnected(c.clients)
+}
+
+// Ping is like IsHealthy but returns an error, and also checks that at least
+// one of the configured nsqlookupds, if any, responds to its /ping endpoint
+// within a short timeout. It suits a readiness probe.
+func (c *Consumer) Ping() error {
+	if !c.IsHealthy() {
+		return errUnhealthy
+	}
+
+	lookupds := c.lookupdAddrs()
+	if len(lookupds) == 0 {
+		return nil
+	}
+
+	client := c.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: pingTimeout}
+	}
+
+	return pingLookupds(lookupds, client)
+}
+
+func allConnected(clients []*nsq.Consumer) bool {
+	for _, client := range clients {
+		if client.Stats().Connections == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,410 @@
+package consumer_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+var nopHandler = consumer.HandlerFunc(func(*nsq.Message) error { return nil })
+
+// Timeout of the waits in tests
+const testTimeout = 5 * time.Second
+
+// FakeLookupd starts an nsqlookupd HTTP API answering every lookup
+// with given nsqd TCP addresses, and returns its address.
+func fakeLookupd(t *testing.T, nsqds ...string) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		producers := make([]string, 0, len(nsqds))
+		for _, a := range nsqds {
+			host, port, _ := net.SplitHostPort(a)
+			producers = append(producers, fmt.Sprintf(`{"broadcast_address":%q,"tcp_port":%s}`, host, port))
+		}
+		w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+		fmt.Fprintf(w, `{"channels":[],"producers":[%s]}`, strings.Join(producers, ","))
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// FakeNSQD speaks just enough of the nsqd TCP protocol for a consumer:
+// it accepts IDENTIFY, SUB and PUB, delivers published messages to the first
+// subscribed connection with RDY > 0, and records the responses.
+type fakeNSQD struct {
+	t  *testing.T
+	ln net.Listener
+
+	// If not nil, IDENTIFY is answered once it is closed
+	hold chan struct{}
+	// If not nil, connections are upgraded to TLS after IDENTIFY
+	tls *tls.Config
+	// Receives a value on every IDENTIFY
+	identified chan struct{}
+	// Receives SUB, FIN, REQ, TOUCH, CLS and PUB commands
+	cmds chan string
+
+	mu    sync.Mutex
+	conns []*fakeConn
+	ready chan struct{}
+}
+
+type fakeConn struct {
+	mu    sync.Mutex
+	conn  net.Conn
+	ready bool
+}
+
+func newFakeNSQD(t *testing.T) *fakeNSQD {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeNSQD{
+		t:          t,
+		ln:         ln,
+		identified: make(chan struct{}, 16),
+		cmds:       make(chan string, 1024),
+		ready:      make(chan struct{}),
+	}
+
+	go d.accept()
+	t.Cleanup(d.close)
+
+	return d
+}
+
+func (d *fakeNSQD) addr() string {
+	return d.ln.Addr().String()
+}
+
+func (d *fakeNSQD) accept() {
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		fc := &fakeConn{conn: conn}
+
+		d.mu.Lock()
+		d.conns = append(d.conns, fc)
+		d.mu.Unlock()
+
+		go d.serve(fc)
+	}
+}
+
+func (d *fakeNSQD) close() {
+	d.ln.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, fc := range d.conns {
+		fc.mu.Lock()
+		fc.conn.Close()
+		fc.mu.Unlock()
+	}
+}
+
+func (d *fakeNSQD) serve(fc *fakeConn) {
+	r := bufio.NewReader(fc.conn)
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		cmd, _, _ := strings.Cut(line, " ")
+
+		switch cmd {
+		case "IDENTIFY":
+			var size int32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return
+			}
+			d.identified <- struct{}{}
+			if d.hold != nil {
+				<-d.hold
+			}
+			if d.tls == nil {
+				fc.write(nsq.FrameTypeResponse, []byte("OK"))
+				continue
+			}
+
+			fc.write(nsq.FrameTypeResponse, []byte(`{"max_rdy_count":2500,"tls_v1":true}`))
+			tc := tls.Server(fc.conn, d.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			fc.mu.Lock()
+			fc.conn = tc
+			fc.mu.Unlock()
+			r = bufio.NewReader(tc)
+			fc.write(nsq.FrameTypeResponse, []byte("OK"))
+		case "SUB":
+			d.cmds <- line
+			fc.write(nsq.FrameTypeResponse, []byte("OK"))
+		case "RDY":
+			fc.mu.Lock()
+			first := !fc.ready && line != "RDY 0"
+			fc.ready = line != "RDY 0"
+			fc.mu.Unlock()
+
+			if first {
+				d.mu.Lock()
+				select {
+				case <-d.ready:
+				default:
+					close(d.ready)
+				}
+				d.mu.Unlock()
+			}
+		case "CLS":
+			d.cmds <- line
+			fc.write(nsq.FrameTypeResponse, []byte("CLOSE_WAIT"))
+		case "FIN", "REQ", "TOUCH":
+			d.cmds <- line
+		case "PUB":
+			var size int32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return
+			}
+			d.cmds <- line
+			fc.write(nsq.FrameTypeResponse, []byte("OK"))
+		}
+	}
+}
+
+func (fc *fakeConn) write(frameType int32, data []byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(frameType))
+	fc.conn.Write(append(buf, data...))
+}
+
+// Publish delivers a message once a connection is ready for it.
+func (d *fakeNSQD) publish(id string, body []byte, attempts uint16) {
+	d.t.Helper()
+
+	select {
+	case <-d.ready:
+	case <-time.After(testTimeout):
+		d.t.Fatal("no connection is ready for messages")
+	}
+
+	var msgID nsq.MessageID
+	copy(msgID[:], id)
+
+	data := make([]byte, 10, 10+len(msgID)+len(body))
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(data[8:], attempts)
+	data = append(data, msgID[:]...)
+	data = append(data, body...)
+
+	d.mu.Lock()
+	var fc *fakeConn
+	for _, c := range d.conns {
+		c.mu.Lock()
+		ready := c.ready
+		c.mu.Unlock()
+		if ready {
+			fc = c
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if fc == nil {
+		d.t.Fatal("no connection is ready for messages")
+	}
+	fc.write(nsq.FrameTypeMessage, data)
+}
+
+// Expect waits for a command with a given prefix, e.g. "FIN" or "REQ <id>".
+func (d *fakeNSQD) expect(prefix string) string {
+	d.t.Helper()
+
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case cmd := <-d.cmds:
+			if strings.HasPrefix(cmd, prefix) {
+				return cmd
+			}
+		case <-timeout:
+			d.t.Fatalf("no %s command received", prefix)
+			return ""
+		}
+	}
+}
+
+// StartedConsumer returns a consumer started with a given handler against
+// a fake nsqlookupd without producers, and stops it at the end of the test.
+func startedConsumer(t *testing.T, h nsq.Handler, options map[string]interface{}) *consumer.Consumer {
+	t.Helper()
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqlookupd", fakeLookupd(t))
+	c.SetMap(options)
+
+	if err := c.Start(h); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { c.Stop() })
+
+	return c
+}
+
+// NewNSQDConsumer returns a consumer of a given fake nsqd.
+func newNSQDConsumer(t *testing.T, d *fakeNSQD) *consumer.Consumer {
+	t.Helper()
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqd", d.addr())
+
+	return c
+}
+
+// LineLogger records the lines written by a consumer.
+type lineLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lineLogger) Output(_ int, s string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, s)
+	return nil
+}
+
+// Contains reports whether a line containing s has been written.
+func (l *lineLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// FakeClock is a consumer clock whose time only moves on advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+	} else {
+		f.timers = append(f.timers, fakeTimer{at: f.now.Add(d), ch: ch})
+	}
+	return ch
+}
+
+// Advance moves the time forward, firing the timers due.
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.timers[:0]
+	for _, tm := range f.timers {
+		if tm.at.After(f.now) {
+			pending = append(pending, tm)
+			continue
+		}
+		tm.ch <- f.now
+	}
+	f.timers = pending
+}
+
+// Pending returns the number of timers not fired yet.
+func (f *fakeClock) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// WaitTimers waits for at least n timers to be pending.
+func (f *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(testTimeout); f.pending() < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers are pending, want %d", f.pending(), n)
+		}
+	}
+}
+
+// Wait waits for a channel to be closed.
+func wait(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
@@ -0,0 +1,90 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// OnMessageFinished registers a hook called when a message is finished,
+// by the handler or automatically after it returns.
+//
+// The message hooks observe the responses made until the message is
+// auto-responded or, with the auto-response disabled, until the handler
+// returns: later responses are sent to nsqd as usual but not observed.
+// The hooks are called synchronously and cannot change the outcome;
+// a panic in a hook is recovered and logged. They must be registered
+// before Start().
+func (c *Consumer) OnMessageFinished(fn func(m *nsq.Message)) {
+	c.finishHooks = append(c.finishHooks, fn)
+}
+
+// OnGiveUp registers a callback called for a message that exceeded
+// max_attempts, right before it is finished and discarded, so the body
+// is intact. It is called regardless of the middlewares, which do not see
+// such a message, and a panic in it is recovered and logged. The number
+// of such messages is reported by GivenUp. It must be registered before Start().
+func (c *Consumer) OnGiveUp(fn func(m *nsq.Message)) {
+	c.giveUpCbs = append(c.giveUpCbs, fn)
+}
+
+// GivenUp returns the number of messages that exceeded max_attempts
+// (or failed permanently, see the `permanent_give_up` option).
+func (c *Consumer) GivenUp() uint64 {
+	return c.activity.givenUp.Load()
+}
+
+// OnMessageRequeued registers a hook called when a message is requeued,
+// with the requested delay (-1 means the default one). See OnMessageFinished.
+func (c *Consumer) OnMessageRequeued(fn func(m *nsq.Message, delay time.Duration)) {
+	c.requeueHooks = append(c.requeueHooks, fn)
+}
+
+// OnMessageTouched registers a hook called when a message is touched.
+// See OnMessageFinished.
+func (c *Consumer) OnMessageTouched(fn func(m *nsq.Message)) {
+	c.touchHooks = append(c.touchHooks, fn)
+}
+
+// ObservingDelegate passes the responses to the original delegate,
+// counting requeues without backoff and calling the message hooks.
+type observingDelegate struct {
+	nsq.MessageDelegate
+	c       *Consumer
+	outcome observedOutcome
+}
+
+func (d *observingDelegate) OnFinish(m *nsq.Message) {
+	d.outcome.set(OutcomeFinished)
+	d.MessageDelegate.OnFinish(m)
+	for _, fn := range d.c.finishHooks {
+		d.c.callHook(m, func() { fn(m) })
+	}
+}
+
+func (d *observingDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	if !backoff {
+		d.c.activity.requeuedNoBackoff.Add(1)
+	}
+	d.outcome.set(OutcomeRequeued)
+	d.MessageDelegate.OnRequeue(m, delay, backoff)
+	for _, fn := range d.c.requeueHooks {
+		d.c.callHook(m, func() { fn(m, delay) })
+	}
+}
+
+func (d *observingDelegate) OnTouch(m *nsq.Message) {
+	d.MessageDelegate.OnTouch(m)
+	for _, fn := range d.c.touchHooks {
+		d.c.callHook(m, func() { fn(m) })
+	}
+}
+
+func (c *Consumer) callHook(m *nsq.Message, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logMsgf(m, nsq.LogLevelError, "message hook panicked on %s: %v", MessageInfo(m), r)
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,130 @@
+package consumer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// The delay of messages requeued because of the in-flight bytes limit
+const inFlightBytesRequeueDelay = time.Second
+
+// Bounds of the DumpInFlight output
+const (
+	dumpMaxMessages = 100
+	dumpMaxBody     = 256
+)
+
+// InFlight tracks the messages currently passed to the handlers.
+type inFlight struct {
+	mu   sync.Mutex
+	msgs map[*nsq.Message]time.Time
+}
+
+func (f *inFlight) add(m *nsq.Message, at time.Time) {
+	f.mu.Lock()
+	if f.msgs == nil {
+		f.msgs = make(map[*nsq.Message]time.Time)
+	}
+	f.msgs[m] = at
+	f.mu.Unlock()
+}
+
+func (f *inFlight) remove(m *nsq.Message) {
+	f.mu.Lock()
+	delete(f.msgs, m)
+	f.mu.Unlock()
+}
+
+func (f *inFlight) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.msgs)
+}
+
+// WithMaxInFlightBytes limits the total size of the bodies of messages
+// being handled at the same time. A message that would exceed the limit
+// is requeued without backoff with a small delay, until some capacity frees.
+// A message larger than the limit is still handled when nothing else is.
+//
+// This complements max_in_flight, which bounds the number of messages
+// but not the memory they take. Only messages already passed to
+// the handlers are accounted; up to max_in_flight messages may still be
+// buffered by go-nsq. Zero means no limit.
+func (c *Consumer) WithMaxInFlightBytes(n int64) *Consumer {
+	c.Set("max_in_flight_bytes", n)
+	return c
+}
+
+// AcquireBytes reserves capacity for the body of a message. It returns false
+// if the message must not be handled; such a message is already requeued.
+func (c *Consumer) acquireBytes(m *nsq.Message) (release func(), ok bool) {
+	if c.maxInFlightBytes <= 0 {
+		return func() {}, true
+	}
+
+	size := int64(len(m.Body))
+
+	if total := atomic.AddInt64(&c.inFlightBytes, size); total > c.maxInFlightBytes && total != size {
+		atomic.AddInt64(&c.inFlightBytes, -size)
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(inFlightBytesRequeueDelay)
+		return nil, false
+	}
+
+	return func() { atomic.AddInt64(&c.inFlightBytes, -size) }, true
+}
+
+// DumpInFlight writes the messages currently being handled to a given writer,
+// the longest-running first: their IDs, attempts, handling time and bodies.
+// It may be called e.g. from a SIGUSR1 handler to see what a stuck consumer
+// is working on.
+//
+// The output is bounded to 100 messages and to 256 bytes of each body.
+// It is a snapshot and inherently racy: the messages may be finished
+// by the time it is written.
+func (c *Consumer) DumpInFlight(w io.Writer) error {
+	type entry struct {
+		m  *nsq.Message
+		at time.Time
+	}
+
+	c.inFlight.mu.Lock()
+	entries := make([]entry, 0, len(c.inFlight.msgs))
+	for m, at := range c.inFlight.msgs {
+		entries = append(entries, entry{m: m, at: at})
+	}
+	c.inFlight.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+
+	now := c.clock.Now()
+
+	if _, err := fmt.Fprintf(w, "%d message(s) in flight\n", len(entries)); err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if i == dumpMaxMessages {
+			_, err := fmt.Fprintf(w, "... %d more\n", len(entries)-i)
+			return err
+		}
+
+		body, suffix := e.m.Body, ""
+		if len(body) > dumpMaxBody {
+			body, suffix = body[:dumpMaxBody], "..."
+		}
+
+		_, err := fmt.Fprintf(w, "%s, handled for %s: %q%s\n",
+			MessageInfo(e.m), now.Sub(e.at).Round(time.Millisecond), body, suffix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
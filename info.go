@@ -0,0 +1,101 @@
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nsqio/go-nsq"
+)
+
+const redacted = "[redacted]"
+
+// Info is the document served by InfoHandler.
+type Info struct {
+	Topic       string                 `json:"topic"`
+	Topics      []string               `json:"topics,omitempty"`
+	Channel     string                 `json:"channel"`
+	State       string                 `json:"state"`
+	Config      map[string]interface{} `json:"config"`
+	Stats       nsq.ConsumerStats      `json:"stats"`
+	MessageAge  HistogramSnapshot      `json:"message_age"`
+	Middlewares []string               `json:"middlewares"`
+}
+
+// InfoHandler returns a read-only HTTP handler that serves the consumer
+// state as JSON, suitable to be mounted at e.g. /debug/nsq:
+//
+//	{
+//	  "topic": "orders",
+//	  "channel": "billing",
+//	  "state": "running",             // "created", "running", "paused" or "stopped"
+//	  "config": {"concurrency": 4, "max_in_flight": 8, ...},
+//	  "stats": {"MessagesReceived": 10, "MessagesFinished": 9, "MessagesRequeued": 1, "Connections": 2},
+//	  "message_age": {"Buckets": [...], "Counts": [...], "Count": 10, "Sum": 1500000},
+//	  "middlewares": ["consumer.LatestOnly"]
+//	}
+//
+// The config is the one of ConfigMap, with secrets such as auth_secret
+// redacted. Durations are in nanoseconds.
+func (c *Consumer) InfoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c.info())
+	})
+}
+
+func (c *Consumer) info() *Info {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	config := c.configMap()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := Info{
+		Topic:      c.topic,
+		Channel:    c.channel,
+		State:      "created",
+		Config:     config,
+		MessageAge: c.msgAge.snapshot(),
+	}
+
+	for _, m := range c.middlewares {
+		i.Middlewares = append(i.Middlewares, m.name)
+	}
+
+	switch {
+	case c.state == stateStopped:
+		i.State = "stopped"
+	case c.state == stateStarted && c.paused:
+		i.State = "paused"
+	case c.state == stateStarted:
+		i.State = "running"
+	}
+
+	if len(c.topics) > 0 {
+		i.Topics = c.allTopics()
+	}
+	i.Stats = sumStats(c.clients)
+
+	return &i
+}
+
+// Stats returns the message and connection counters of the consumer,
+// summed over all its topics. It is safe to call before Start
+// and after Stop; the counters are zero before Start.
+func (c *Consumer) Stats() *nsq.ConsumerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := sumStats(c.clients)
+	return &stats
+}
@@ -0,0 +1,42 @@
+package consumer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestInfoHandlerConfig(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	c.Set("max_in_flight", 8)
+	c.Set("auth_secret", "s3cret")
+	c.Set("dead_letter_topic", "orders.dead")
+
+	rec := httptest.NewRecorder()
+	c.InfoHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/nsq", nil))
+
+	var info consumer.Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	want := c.ConfigMap()
+	if len(info.Config) != len(want) {
+		t.Errorf("info has %d options, ConfigMap %d", len(info.Config), len(want))
+	}
+	if v := info.Config["max_in_flight"]; v != float64(8) {
+		t.Errorf("max_in_flight = %v, want 8", v)
+	}
+	if v := info.Config["auth_secret"]; v != "[redacted]" {
+		t.Errorf("auth_secret = %v, want it redacted", v)
+	}
+	if v := info.Config["dead_letter_topic"]; v != "orders.dead" {
+		t.Errorf("dead_letter_topic = %v", v)
+	}
+	if info.State != "created" {
+		t.Errorf("state = %q, want created", info.State)
+	}
+}
@@ -0,0 +1,66 @@
+// Package jsonschema implements a JSON Schema validator for message bodies.
+//
+// It lives in its own package so that the schema library is pulled only
+// by those who use it.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	schema "github.com/santhosh-tekuri/jsonschema/v5"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// Validator validates message bodies against a compiled JSON Schema.
+type Validator struct {
+	schema *schema.Schema
+}
+
+// New compiles a given JSON Schema document and returns a new validator.
+func New(doc []byte) (*Validator, error) {
+	c := schema.NewCompiler()
+	if err := c.AddResource("schema.json", bytes.NewReader(doc)); err != nil {
+		return nil, err
+	}
+
+	s, err := c.Compile("schema.json")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{schema: s}, nil
+}
+
+// Validate implements the consumer.Validator interface.
+func (v *Validator) Validate(body []byte) error {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON document")
+	}
+
+	return v.schema.Validate(doc)
+}
+
+// WithJSONSchema compiles a given schema and installs it as the validator
+// of the consumer. See consumer.WithValidator for the policy applied
+// to messages that fail validation.
+func WithJSONSchema(c *consumer.Consumer, doc []byte) error {
+	v, err := New(doc)
+	if err != nil {
+		return err
+	}
+	c.WithValidator(v)
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package jsonschema_test
+
+import (
+	"testing"
+
+	"github.com/0xef53/nsq-consumer/jsonschema"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id"],
+	"properties": {"id": {"type": "integer"}}
+}`
+
+func TestValidate(t *testing.T) {
+	v, err := jsonschema.New([]byte(orderSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		body  string
+		valid bool
+	}{
+		{`{"id": 1}`, true},
+		{" {\"id\": 1}\n", true},
+		{`{"id": "1"}`, false},
+		{`{}`, false},
+		{`{"id": 1}xyz`, false},
+		{`{"id": 1} {"id": 2}`, false},
+		{`{"id": 1`, false},
+		{``, false},
+	} {
+		if err := v.Validate([]byte(tt.body)); (err == nil) != tt.valid {
+			t.Errorf("%q: got error %v, want valid %t", tt.body, err, tt.valid)
+		}
+	}
+}
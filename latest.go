@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+type latestOnly struct {
+	mu     sync.Mutex
+	seq    uint64
+	latest map[string]uint64
+
+	keyFn  func(*nsq.Message) string
+	window time.Duration
+	clock  clock
+}
+
+// LatestOnly returns a middleware that approximates topic compaction:
+// every message is held for a given window, and if a newer message with
+// the same key arrives in the meantime, the older one is finished
+// without calling the handler.
+//
+// This is only an approximation: messages of the same key are collapsed
+// only if they are delivered to this process within the window. Each held
+// message occupies a handler goroutine and counts against max_in_flight,
+// so `concurrency` and `max_in_flight` must be large enough to hold a burst,
+// and the window must be well below msg_timeout. Every message is delayed by
+// the window. Memory usage is proportional to the number of distinct keys
+// held at the same time.
+func LatestOnly(keyFn func(*nsq.Message) string, window time.Duration) Middleware {
+	return newLatestOnly(keyFn, window, realClock{}).wrap
+}
+
+func newLatestOnly(keyFn func(*nsq.Message) string, window time.Duration, cl clock) *latestOnly {
+	return &latestOnly{
+		latest: make(map[string]uint64),
+		keyFn:  keyFn,
+		window: window,
+		clock:  cl,
+	}
+}
+
+func (l *latestOnly) wrap(next nsq.Handler) nsq.Handler {
+	return HandlerFunc(func(m *nsq.Message) error {
+		key := l.keyFn(m)
+
+		l.mu.Lock()
+		l.seq++
+		seq := l.seq
+		l.latest[key] = seq
+		l.mu.Unlock()
+
+		<-l.clock.After(l.window)
+
+		l.mu.Lock()
+		latest := l.latest[key] == seq
+		if latest {
+			delete(l.latest, key)
+		}
+		l.mu.Unlock()
+
+		if !latest {
+			// A newer message with the same key has arrived
+			return nil
+		}
+
+		return next.HandleMessage(m)
+	})
+}
+
+// WithLatestOnly registers the LatestOnly middleware.
+// See LatestOnly for details and tradeoffs.
+func (c *Consumer) WithLatestOnly(keyFn func(*nsq.Message) string, window time.Duration) *Consumer {
+	c.UseNamed("consumer.LatestOnly", newLatestOnly(keyFn, window, c.clock).wrap)
+	return c
+}
@@ -0,0 +1,47 @@
+package consumer
+
+import (
+	"github.com/nsqio/go-nsq"
+)
+
+// LeveledLogger is the interface of leveled loggers such as the sugared zap
+// logger or logrus, to be set with SetLeveledLogger.
+type LeveledLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LoggerFunc is an adapter to use a function as the logger. It receives
+// the level and the message of every line, without the level prefix.
+type LoggerFunc func(level nsq.LogLevel, msg string)
+
+// Output implements the logger interface of go-nsq.
+func (f LoggerFunc) Output(_ int, line string) error {
+	f(parseLogLine(line))
+	return nil
+}
+
+// SetLeveledLogger replaces the logger with a leveled one: the level of each
+// line is parsed out of its prefix and the line goes to the method
+// of that level (Info for lines without a known prefix).
+func (c *Consumer) SetLeveledLogger(l LeveledLogger, level nsq.LogLevel) {
+	if isNil(l) {
+		c.SetLogger(nil, level)
+		return
+	}
+
+	c.SetLogger(LoggerFunc(func(lvl nsq.LogLevel, msg string) {
+		switch lvl {
+		case nsq.LogLevelDebug:
+			l.Debugf("%s", msg)
+		case nsq.LogLevelWarning:
+			l.Warnf("%s", msg)
+		case nsq.LogLevelError:
+			l.Errorf("%s", msg)
+		default:
+			l.Infof("%s", msg)
+		}
+	}), level)
+}
@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// LoadJSON sets options from a JSON object of option name to value,
+// with the same names as Set:
+//
+//	{"topic": "orders", "channel": "billing", "nsqlookupds": ["a:4161", "b:4161"],
+//	 "concurrency": 4, "max_in_flight": 16, "lookupd_poll_interval": "15s"}
+//
+// Malformed JSON is returned immediately. Errors of the options themselves
+// are returned by Start() as with Set.
+func (c *Consumer) LoadJSON(r io.Reader) error {
+	var options map[string]interface{}
+
+	if err := json.NewDecoder(r).Decode(&options); err != nil {
+		return fmt.Errorf("failed to decode JSON options: %v", err)
+	}
+
+	c.LoadMap(options)
+
+	return nil
+}
+
+// LoadJSONFile is like LoadJSON but reads the options from a file.
+func (c *Consumer) LoadJSONFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.LoadJSON(f)
+}
+
+// LoadMap is like SetMap but for options decoded from a file or another
+// untyped source: the values are converted to the types the options
+// expect (see coerceValue) before they are passed to Set.
+func (c *Consumer) LoadMap(options map[string]interface{}) {
+	coerced := make(map[string]interface{}, len(options))
+	for k, v := range options {
+		coerced[k] = coerceValue(k, v)
+	}
+	c.SetMap(coerced)
+}
+
+// CoerceValue converts a value decoded from a file: whole numbers
+// become int, lists become []string if all the items are strings,
+// and strings are handled as by coerceString. Integers and booleans
+// (e.g. from YAML) are already of the right type.
+func coerceValue(option string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v)
+		}
+	case string:
+		return coerceString(option, v)
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return value
+			}
+			ss = append(ss, s)
+		}
+		return ss
+	}
+	return value
+}
@@ -0,0 +1,48 @@
+package consumer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LookupdClient returns the HTTP client for nsqlookupd requests
+// made by the consumer itself.
+func (c *Consumer) lookupdClient() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return &http.Client{Timeout: c.config.LookupdPollTimeout}
+}
+
+// PingLookupds returns nil if at least one of the given nsqlookupds
+// responds to its /ping endpoint.
+func pingLookupds(addrs []string, client *http.Client) error {
+	var lastErr error
+
+	for _, addr := range addrs {
+		if lastErr = pingLookupd(client, addr); lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no nsqlookupd is reachable: %v", lastErr)
+}
+
+func pingLookupd(client *http.Client, addr string) error {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	resp, err := client.Get(strings.TrimRight(addr, "/") + "/ping")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", addr, resp.Status)
+	}
+
+	return nil
+}
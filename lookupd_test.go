@@ -0,0 +1,55 @@
+package consumer_test
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// UnreachableAddr returns the address of a closed local port.
+func unreachableAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	return addr
+}
+
+func TestLookupdFallbackToNSQD(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d).WithLookupdFallbackToNSQD()
+	c.Set("nsqlookupd", unreachableAddr(t))
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatalf("start with an unreachable nsqlookupd: %v", err)
+	}
+	defer c.Stop()
+
+	select {
+	case <-d.identified:
+	case <-time.After(testTimeout):
+		t.Fatal("the fallback nsqd is not connected to")
+	}
+}
+
+func TestLookupdFallbackUnused(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d).WithLookupdFallbackToNSQD()
+	c.Set("nsqlookupd", fakeLookupd(t))
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	select {
+	case <-d.identified:
+		t.Fatal("the fallback nsqd is connected to while nsqlookupd is reachable")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,42 @@
+package consumer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// MsgInfo is a typed view of the attributes nsqd assigns to a message.
+type MsgInfo struct {
+	// ID is the message ID; nsqd generates it as 16 hex characters
+	ID string
+	// Attempts is the number of delivery attempts, including the current one
+	Attempts uint16
+	// Timestamp is the time the message was published to nsqd
+	Timestamp time.Time
+	// NSQDAddress is the address of the nsqd that delivered the message,
+	// empty for a message that did not come from a connection
+	NSQDAddress string
+	// BodySize is the length of the body in bytes
+	BodySize int
+}
+
+// MessageInfo returns the attributes of a given message.
+func MessageInfo(m *nsq.Message) MsgInfo {
+	return MsgInfo{
+		ID:          string(m.ID[:]),
+		Attempts:    m.Attempts,
+		Timestamp:   time.Unix(0, m.Timestamp),
+		NSQDAddress: m.NSQDAddress,
+		BodySize:    len(m.Body),
+	}
+}
+
+// String returns a short description of the message for logging.
+func (i MsgInfo) String() string {
+	if i.NSQDAddress == "" {
+		return fmt.Sprintf("msg %s (attempts: %d)", i.ID, i.Attempts)
+	}
+	return fmt.Sprintf("msg %s (attempts: %d, nsqd: %s)", i.ID, i.Attempts, i.NSQDAddress)
+}
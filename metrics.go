@@ -0,0 +1,134 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMessageAgeBuckets are the upper bounds of the message age histogram
+// used unless overridden with the `message_age_buckets` option.
+var DefaultMessageAgeBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
+// DefaultHandlerDurationBuckets are the upper bounds of the handler duration histogram.
+var DefaultHandlerDurationBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram.
+//
+// Counts[i] is the cumulative number of observations less than or equal
+// to Buckets[i]. Count is the total number of observations (the implicit
+// +Inf bucket) and Sum is their total.
+type HistogramSnapshot struct {
+	Buckets []time.Duration
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	// Index of the first bucket the value fits into
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += d
+	h.mu.Unlock()
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := HistogramSnapshot{
+		Buckets: append([]time.Duration(nil), h.bounds...),
+		Counts:  make([]uint64, len(h.counts)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+
+	var acc uint64
+	for i, n := range h.counts {
+		acc += n
+		s.Counts[i] = acc
+	}
+
+	return s
+}
+
+// ParseBuckets converts an option value into a sorted list of bucket bounds.
+func parseBuckets(value interface{}) ([]time.Duration, error) {
+	var bounds []time.Duration
+
+	switch v := value.(type) {
+	case []time.Duration:
+		bounds = append(bounds, v...)
+	default:
+		ss, err := split(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected string, slice of strings or slice of durations")
+		}
+		for _, s := range ss {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, err
+			}
+			bounds = append(bounds, d)
+		}
+	}
+
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("at least one bucket must be specified")
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return nil, fmt.Errorf("buckets must be in increasing order")
+		}
+	}
+
+	return bounds, nil
+}
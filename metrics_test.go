@@ -0,0 +1,35 @@
+package consumer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestMessageAgeAndHandlerDuration(t *testing.T) {
+	clk := newFakeClock()
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+
+	m := consumertest.NewMessage([]byte("1"), nil)
+	m.Timestamp = clk.Now().Add(-3 * time.Second).UnixNano()
+
+	// The handler takes 2s of the fake time
+	h := consumer.HandlerFunc(func(*nsq.Message) error {
+		clk.advance(2 * time.Second)
+		return nil
+	})
+	if err := consumertest.Deliver(c, h, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if age := c.MessageAge(); age.Count != 1 || age.Sum != 3*time.Second {
+		t.Errorf("got message age count %d, sum %s, want 1 and 3s", age.Count, age.Sum)
+	}
+	if dur := c.HandlerDuration(); dur.Count != 1 || dur.Sum != 2*time.Second {
+		t.Errorf("got handler duration count %d, sum %s, want 1 and 2s", dur.Count, dur.Sum)
+	}
+}
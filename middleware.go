@@ -0,0 +1,103 @@
+package consumer
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Middleware wraps a handler to add some cross-cutting logic around it,
+// such as logging, metrics or panic recovery:
+//
+//	func Timing(next nsq.Handler) nsq.Handler {
+//		return consumer.HandlerFunc(func(m *nsq.Message) error {
+//			start := time.Now()
+//			err := next.HandleMessage(m)
+//			log.Printf("msg %s handled in %s", m.ID, time.Since(start))
+//			return err
+//		})
+//	}
+//
+// The error of the next handler should be returned as is: it is what makes
+// the consumer finish (nil) or requeue (non-nil) the message.
+type Middleware func(nsq.Handler) nsq.Handler
+
+type namedMiddleware struct {
+	name string
+	mw   Middleware
+}
+
+// Use registers middlewares that wrap the handler passed to Start().
+// They are applied in registration order: the first one is the outermost.
+//
+// A middleware registered with Use is named after the function that
+// created it, with the package path and the closure suffix trimmed.
+// For example, a middleware returned by consumer.Recoverer() is named
+// "consumer.Recoverer". Use UseNamed to give it an explicit name.
+//
+// Middlewares registered after Start() are ignored with a warning.
+func (c *Consumer) Use(mw ...Middleware) {
+	for _, m := range mw {
+		c.UseNamed(funcName(m), m)
+	}
+}
+
+// UseNamed registers a middleware with a given name.
+func (c *Consumer) UseNamed(name string, mw Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active() {
+		c.logf(nsq.LogLevelWarning, "middleware %q is registered after start, ignoring", name)
+		return
+	}
+
+	c.middlewares = append(c.middlewares, namedMiddleware{name: name, mw: mw})
+}
+
+// Middlewares returns the names of the registered middlewares
+// in application order, the outermost first.
+func (c *Consumer) Middlewares() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, 0, len(c.middlewares))
+	for _, m := range c.middlewares {
+		names = append(names, m.name)
+	}
+
+	return names
+}
+
+// Chain wraps a given handler with all registered middlewares.
+func (c *Consumer) chain(handler nsq.Handler) nsq.Handler {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i].mw(handler)
+	}
+	return handler
+}
+
+func funcName(mw Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+
+	// github.com/user/pkg.Func.func1 -> pkg.Func
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	for {
+		i := strings.LastIndex(name, ".func")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+
+	return name
+}
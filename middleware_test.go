@@ -0,0 +1,90 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+// Tracing is an example middleware recording the order it runs in.
+func tracing(name string, trace *[]string) consumer.Middleware {
+	return func(next nsq.Handler) nsq.Handler {
+		return consumer.HandlerFunc(func(m *nsq.Message) error {
+			*trace = append(*trace, name+" in")
+			err := next.HandleMessage(m)
+			*trace = append(*trace, name+" out")
+			return err
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var trace []string
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(tracing("first", &trace), tracing("second", &trace))
+
+	h := consumer.HandlerFunc(func(*nsq.Message) error {
+		trace = append(trace, "handler")
+		return nil
+	})
+
+	d, err := consumertest.Handle(c, h, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Finished() {
+		t.Error("the message is not finished")
+	}
+
+	want := []string{"first in", "second in", "handler", "second out", "first out"}
+	if len(trace) != len(want) {
+		t.Fatalf("trace %v, want %v", trace, want)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("trace %v, want %v", trace, want)
+		}
+	}
+}
+
+func TestMiddlewareKeepsRequeue(t *testing.T) {
+	var trace []string
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(tracing("mw", &trace))
+
+	boom := errors.New("boom")
+	d, err := consumertest.Handle(c, consumer.HandlerFunc(func(*nsq.Message) error { return boom }), nil)
+	if !errors.Is(err, boom) {
+		t.Fatalf("got error %v, want the handler one", err)
+	}
+	if requeued, _, _ := d.Requeued(); !requeued || d.Finished() {
+		t.Error("the failed message is not requeued")
+	}
+}
+
+func TestUseAfterStart(t *testing.T) {
+	l := &lineLogger{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(l, nsq.LogLevelWarning)
+	c.Set("nsqlookupd", fakeLookupd(t))
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	c.UseNamed("late", func(next nsq.Handler) nsq.Handler { return next })
+
+	if len(c.Middlewares()) != 0 {
+		t.Errorf("middlewares %v, want none", c.Middlewares())
+	}
+	if !l.contains(`middleware "late" is registered after start, ignoring`) {
+		t.Error("the ignored middleware is not logged")
+	}
+}
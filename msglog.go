@@ -0,0 +1,17 @@
+package consumer
+
+import (
+	"github.com/nsqio/go-nsq"
+)
+
+// OnMessageLog registers a hook receiving every log line the consumer emits
+// about a specific message (invalid bodies, exceeded attempts, quarantine
+// failures and so on), along with handler errors which are otherwise logged
+// by go-nsq itself. The message context (see Context) is still available, so
+// the hook may correlate the line with e.g. the tracing span of the message.
+//
+// Hooks are called synchronously in the handler goroutine and must be
+// registered before Start().
+func (c *Consumer) OnMessageLog(fn func(m *nsq.Message, level nsq.LogLevel, line string)) {
+	c.msgLogHooks = append(c.msgLogHooks, fn)
+}
@@ -0,0 +1,110 @@
+package consumer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Outcome is the response to a message observed by an Observer.
+type Outcome int32
+
+const (
+	// OutcomePending means the handler disabled the auto-response
+	// and has not responded to the message by the time it returned.
+	OutcomePending Outcome = iota
+	// OutcomeFinished means the message was finished.
+	OutcomeFinished
+	// OutcomeRequeued means the message was requeued.
+	OutcomeRequeued
+	// OutcomeGaveUp means the message was given up (see OnGiveUp).
+	OutcomeGaveUp
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeFinished:
+		return "finished"
+	case OutcomeRequeued:
+		return "requeued"
+	case OutcomeGaveUp:
+		return "gave_up"
+	}
+	return "pending"
+}
+
+// Observer is notified around the handling of every message, e.g. to export
+// metrics to a library of choice. The methods are called synchronously on
+// the handler path, so they must be fast and must not block; a panic in them
+// is recovered and logged.
+//
+// MessageDone is called after the message is responded to, with the time
+// spent since MessageStarted. A message exceeded max_attempts is reported
+// with OutcomeGaveUp and a zero duration, as the handler is not called.
+type Observer interface {
+	MessageStarted(topic, channel string, attempts uint16)
+	MessageDone(topic, channel string, outcome Outcome, duration time.Duration)
+}
+
+// SetObserver sets an observer of the message outcomes.
+// Use MultiObserver for several ones. It must be called before Start().
+func (c *Consumer) SetObserver(o Observer) {
+	c.observer = o
+}
+
+// MultiObserver returns an Observer notifying given observers in order.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(append([]Observer(nil), observers...))
+}
+
+type multiObserver []Observer
+
+func (mo multiObserver) MessageStarted(topic, channel string, attempts uint16) {
+	for _, o := range mo {
+		o.MessageStarted(topic, channel, attempts)
+	}
+}
+
+func (mo multiObserver) MessageDone(topic, channel string, outcome Outcome, duration time.Duration) {
+	for _, o := range mo {
+		o.MessageDone(topic, channel, outcome, duration)
+	}
+}
+
+// ObservedOutcome records the response observed by the delegate.
+type observedOutcome struct {
+	v atomic.Int32
+}
+
+func (o *observedOutcome) set(v Outcome) {
+	// Giving up is followed by finishing, which must not override it
+	if Outcome(o.v.Load()) != OutcomeGaveUp {
+		o.v.Store(int32(v))
+	}
+}
+
+func (o *observedOutcome) get() Outcome {
+	return Outcome(o.v.Load())
+}
+
+// MarkGivenUp marks a message being handled as given up for the Observer.
+func markGivenUp(m *nsq.Message) {
+	if d, ok := m.Delegate.(*observingDelegate); ok {
+		d.outcome.set(OutcomeGaveUp)
+	}
+}
+
+func (c *Consumer) observeStarted(topic string, m *nsq.Message) {
+	if c.observer == nil {
+		return
+	}
+	c.callHook(m, func() { c.observer.MessageStarted(topic, c.channel, m.Attempts) })
+}
+
+func (c *Consumer) observeDone(topic string, m *nsq.Message, o Outcome, d time.Duration) {
+	if c.observer == nil {
+		return
+	}
+	c.callHook(m, func() { c.observer.MessageDone(topic, c.channel, o, d) })
+}
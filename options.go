@@ -0,0 +1,106 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Option configures a consumer created by NewConsumerWithOptions.
+type Option func(*Consumer) error
+
+// NewConsumerWithOptions returns a new consumer of a given topic and channel
+// configured with given options. Unlike NewConsumer followed by Set calls,
+// an invalid name or option is returned right away instead of by Start.
+func NewConsumerWithOptions(topic, channel string, opts ...Option) (*Consumer, error) {
+	if !nsq.IsValidTopicName(topic) {
+		return nil, fmt.Errorf("invalid topic name %q", topic)
+	}
+	if !nsq.IsValidChannelName(channel) {
+		return nil, fmt.Errorf("invalid channel name %q", channel)
+	}
+
+	c := NewConsumer(topic, channel)
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ConfigValue sets an option by its Set key, e.g. ConfigValue("max_in_flight", 10).
+func ConfigValue(option string, value interface{}) Option {
+	return func(c *Consumer) error {
+		return c.trySet(option, value)
+	}
+}
+
+// Nsqds sets the nsqd addresses to connect to directly.
+func Nsqds(addrs ...string) Option {
+	return func(c *Consumer) error {
+		if err := checkAddrList(addrs); err != nil {
+			return fmt.Errorf("%q: %v", "nsqds", err)
+		}
+		return c.trySet("nsqds", addrs)
+	}
+}
+
+// Nsqlookupds sets the nsqlookupd addresses.
+func Nsqlookupds(addrs ...string) Option {
+	return func(c *Consumer) error {
+		if err := checkAddrList(addrs); err != nil {
+			return fmt.Errorf("%q: %v", "nsqlookupds", err)
+		}
+		return c.trySet("nsqlookupds", addrs)
+	}
+}
+
+// Concurrency sets the number of concurrent handlers, which must be positive.
+func Concurrency(n int) Option {
+	return func(c *Consumer) error {
+		if n <= 0 {
+			return fmt.Errorf("%q: must be positive", "concurrency")
+		}
+		return c.trySet("concurrency", n)
+	}
+}
+
+// Logger replaces the default NSQ logger (see SetLogger).
+func Logger(log logger, level nsq.LogLevel) Option {
+	return func(c *Consumer) error {
+		c.SetLogger(log, level)
+		return nil
+	}
+}
+
+// TrySet is like Set but returns the error instead of storing it.
+func (c *Consumer) trySet(option string, value interface{}) error {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	if err := c.immutable(option); err != nil {
+		return err
+	}
+
+	c.errMu.Lock()
+	n := len(c.errs)
+	c.errMu.Unlock()
+
+	c.set(option, value)
+
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	if len(c.errs) == n {
+		return nil
+	}
+
+	err := errors.Join(c.errs[n:]...)
+	c.errs = c.errs[:n]
+
+	return err
+}
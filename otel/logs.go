@@ -0,0 +1,42 @@
+// Package otel integrates the consumer with OpenTelemetry.
+//
+// It lives in its own package so that the OpenTelemetry modules are
+// pulled only by those who use it.
+package otel
+
+import (
+	"github.com/nsqio/go-nsq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// WithOTelLogs records the log lines the consumer emits about a message
+// (see consumer.OnMessageLog) as events of the span found in the message
+// context. So they appear in the trace view next to the processing span
+// and are correlated with it by its trace and span IDs.
+//
+// A tracing middleware must put the span into the message context
+// (see consumer.SetContext); lines about messages without a recording span
+// are only written to the consumer logger as usual. The line of a handler
+// error comes after the span is ended, so OTelMiddleware records it itself.
+func WithOTelLogs(c *consumer.Consumer) *consumer.Consumer {
+	c.OnMessageLog(func(m *nsq.Message, level nsq.LogLevel, line string) {
+		addLogEvent(trace.SpanFromContext(consumer.Context(m)), m, level, line)
+	})
+
+	return c
+}
+
+func addLogEvent(span trace.Span, m *nsq.Message, level nsq.LogLevel, line string) {
+	if !span.IsRecording() {
+		return
+	}
+
+	span.AddEvent("log", trace.WithAttributes(
+		attribute.String("log.severity", level.String()),
+		attribute.String("log.message", line),
+		attribute.String("messaging.message.id", consumer.MessageInfo(m).ID),
+	))
+}
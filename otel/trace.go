@@ -0,0 +1,77 @@
+package otel
+
+import (
+	"github.com/nsqio/go-nsq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// OTelMiddleware returns a middleware that starts a consumer span named
+// "<topic> process" per message, with the messaging.* attributes of the
+// message, and puts it into the message context (see consumer.Context),
+// so the inner middlewares and the handler may start child spans.
+// The traceparent of the span is also set with consumer.WithTraceParent
+// for the envelopes of the messages given up after a handler error.
+//
+// The span continues the remote trace whose context is extracted
+// by the global propagator (see otel.SetTextMapPropagator) from
+// the carrier returned by extract, e.g. a field of the message envelope.
+// A nil extract, or a nil carrier, starts a new trace.
+//
+// A handler error is recorded on the span and sets its status to Error.
+// It is also added as the "log" event of WithOTelLogs, since the consumer
+// logs the error only after the middleware returns and the span is ended.
+// With the global no-op propagator the carrier is not extracted at all,
+// and the attributes are not built for a span that is not recording,
+// so the middleware costs next to nothing without a TracerProvider.
+func OTelMiddleware(tracer trace.Tracer, extract func(*nsq.Message) propagation.TextMapCarrier) consumer.Middleware {
+	return func(next nsq.Handler) nsq.Handler {
+		return consumer.HandlerFunc(func(m *nsq.Message) error {
+			topic, channel := consumer.Subscription(m)
+			ctx := consumer.Context(m)
+
+			if prop := otel.GetTextMapPropagator(); extract != nil && len(prop.Fields()) > 0 {
+				if carrier := extract(m); carrier != nil {
+					ctx = prop.Extract(ctx, carrier)
+				}
+			}
+
+			ctx, span := tracer.Start(ctx, topic+" process", trace.WithSpanKind(trace.SpanKindConsumer))
+			defer span.End()
+
+			if span.IsRecording() {
+				info := consumer.MessageInfo(m)
+				span.SetAttributes(
+					attribute.String("messaging.system", "nsq"),
+					attribute.String("messaging.operation", "process"),
+					attribute.String("messaging.destination.name", topic),
+					attribute.String("messaging.nsq.channel", channel),
+					attribute.String("messaging.message.id", info.ID),
+					attribute.Int("messaging.message.body.size", info.BodySize),
+					attribute.Int("messaging.nsq.attempts", int(info.Attempts)),
+				)
+			}
+
+			if span.SpanContext().IsValid() {
+				carrier := propagation.MapCarrier{}
+				propagation.TraceContext{}.Inject(ctx, carrier)
+				ctx = consumer.WithTraceParent(ctx, carrier.Get("traceparent"))
+			}
+			consumer.SetContext(m, ctx)
+
+			err := next.HandleMessage(m)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				addLogEvent(span, m, nsq.LogLevelError, "handler returned error: "+err.Error())
+			}
+
+			return err
+		})
+	}
+}
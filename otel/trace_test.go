@@ -0,0 +1,178 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+// RecordingTracer starts spans recording their events until they end.
+// The spans have the span context sc.
+type recordingTracer struct {
+	noop.Tracer
+	sc trace.SpanContext
+
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &recordingSpan{name: name, sc: t.sc}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type recordingSpan struct {
+	noop.Span
+
+	sc     trace.SpanContext
+	mu     sync.Mutex
+	name   string
+	ended  bool
+	status codes.Code
+	events []string
+}
+
+func (s *recordingSpan) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.ended
+}
+
+func (s *recordingSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func (s *recordingSpan) End(...trace.SpanEndOption) {
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) {
+	s.mu.Lock()
+	s.status = code
+	s.mu.Unlock()
+}
+
+func (s *recordingSpan) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+	for _, a := range cfg.Attributes() {
+		if a.Key == "log.message" {
+			name += ": " + a.Value.AsString()
+		}
+	}
+
+	s.mu.Lock()
+	s.events = append(s.events, name)
+	s.mu.Unlock()
+}
+
+func TestMiddlewareRecordsHandlerError(t *testing.T) {
+	tracer := &recordingTracer{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	WithOTelLogs(c)
+	c.Use(OTelMiddleware(tracer, nil))
+
+	h := consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("boom") })
+	if _, err := consumertest.Handle(c, h, []byte("{}")); err == nil {
+		t.Fatal("expected the handler error")
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+
+	if span.name != "orders process" {
+		t.Errorf("span name %q", span.name)
+	}
+	if !span.ended {
+		t.Error("span is not ended")
+	}
+	if span.status != codes.Error {
+		t.Errorf("span status %v, want Error", span.status)
+	}
+
+	var n int
+	for _, e := range span.events {
+		if e == "log: handler returned error: boom" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("got %d log events of the handler error in %q, want 1", n, span.events)
+	}
+}
+
+func TestMiddlewareSuccess(t *testing.T) {
+	tracer := &recordingTracer{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(OTelMiddleware(tracer, nil))
+
+	h := consumer.HandlerFunc(func(m *nsq.Message) error {
+		if !trace.SpanFromContext(consumer.Context(m)).IsRecording() {
+			t.Error("no recording span in the message context")
+		}
+		return nil
+	})
+	d, err := consumertest.Handle(c, h, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Finished() {
+		t.Error("message is not finished")
+	}
+	if span := tracer.spans[0]; span.status == codes.Error || len(span.events) != 0 {
+		t.Errorf("unexpected status %v or events %q", span.status, span.events)
+	}
+}
+
+func TestMiddlewareTraceParent(t *testing.T) {
+	tid, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	sid, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	tracer := &recordingTracer{sc: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+	})}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.Use(OTelMiddleware(tracer, nil))
+
+	var got string
+	h := consumer.HandlerFunc(func(m *nsq.Message) error {
+		got = consumer.TraceParent(consumer.Context(m))
+		return nil
+	})
+	if _, err := consumertest.Handle(c, h, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+
+	// No traceparent without a valid span
+	c = consumer.NewConsumer("orders", "billing")
+	c.Use(OTelMiddleware(&recordingTracer{}, nil))
+	if _, err := consumertest.Handle(c, h, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Errorf("got traceparent %q of an invalid span", got)
+	}
+}
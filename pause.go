@@ -0,0 +1,153 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// MsgLimit is a budget of messages to process before pausing.
+type msgLimit struct {
+	n    int64
+	left int64
+	done int64
+	ch   chan struct{}
+
+	// IDs of the messages with a reservation passed to the user handler
+	handled sync.Map
+}
+
+// Take reserves one message of the budget. It returns false
+// if the budget is exhausted.
+func (l *msgLimit) take() bool {
+	return atomic.AddInt64(&l.left, -1) >= 0
+}
+
+// Release ends the reservation of a message. The budget is spent only if
+// the message has been passed to the user handler, otherwise it is returned.
+// It reports whether it was the last message of the budget.
+func (l *msgLimit) release(m *nsq.Message) (last bool) {
+	if _, ok := l.handled.LoadAndDelete(m.ID); !ok {
+		atomic.AddInt64(&l.left, 1)
+		return false
+	}
+	return atomic.AddInt64(&l.done, 1) == l.n
+}
+
+// ProcessN starts the consumer with a given handler, processes exactly n
+// messages and then pauses it by lowering max-in-flight to zero.
+// The connections are kept open, so consumption may be continued with Resume().
+//
+// Only the messages passed to the handler count: those dropped before it,
+// e.g. as invalid, by a broken gzip body or by a middleware like Dedupe,
+// do not. Messages delivered beyond the budget (possible with max_in_flight > 1)
+// are requeued without backoff and not passed to the handler.
+//
+// ProcessN blocks until all n messages have been handled and the consumer
+// is paused, returning nil, or until the consumer is stopped, returning
+// ErrStopped. Unlike a WithMaxMessages-style limit, which stops the consumer
+// once the count is reached (this package has none), ProcessN tears nothing
+// down: call Stop after it returns to get that behavior.
+func (c *Consumer) ProcessN(handler nsq.Handler, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("the number of messages must be positive")
+	}
+
+	l := &msgLimit{
+		n:    int64(n),
+		left: int64(n),
+		ch:   make(chan struct{}),
+	}
+	c.limit.Store(l)
+
+	if err := c.Start(handler); err != nil {
+		c.limit.CompareAndSwap(l, nil)
+		return err
+	}
+
+	select {
+	case <-l.ch:
+	case <-c.done:
+		return ErrStopped
+	}
+
+	return nil
+}
+
+// Pause stops receiving new messages by lowering max-in-flight to zero.
+// The connections are kept open and the messages in flight are handled
+// as usual. Called before Start, it makes the consumer start paused.
+//
+// It is safe to call Pause and Resume repeatedly and concurrently.
+func (c *Consumer) Pause() {
+	c.pause()
+}
+
+// Resume restores the configured max-in-flight after a pause
+// and removes any message budget set by ProcessN.
+func (c *Consumer) Resume() {
+	c.limit.Store(nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+	for _, client := range c.clients {
+		client.ChangeMaxInFlight(c.maxInFlight())
+	}
+}
+
+// Paused reports whether the consumer is paused.
+func (c *Consumer) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+func (c *Consumer) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+	for _, client := range c.clients {
+		client.ChangeMaxInFlight(0)
+	}
+}
+
+// Limited applies the message budget, if any. It returns false if the message
+// must not be handled; such a message is already requeued.
+func (h *wrappedHandler) limited(m *nsq.Message) (release func(), ok bool) {
+	l := h.c.limit.Load()
+	if l == nil {
+		return func() {}, true
+	}
+
+	if !l.take() {
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(0)
+		return nil, false
+	}
+
+	return func() {
+		if l.release(m) {
+			go func() {
+				h.c.pause()
+				close(l.ch)
+			}()
+		}
+	}, true
+}
+
+// CountHandled marks the messages reaching the user handler
+// as spent from the message budget, if any.
+func (c *Consumer) countHandled(handler nsq.Handler) nsq.Handler {
+	return HandlerFunc(func(m *nsq.Message) error {
+		if l := c.limit.Load(); l != nil {
+			l.handled.Store(m.ID, struct{}{})
+		}
+		return handler.HandleMessage(m)
+	})
+}
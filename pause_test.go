@@ -0,0 +1,108 @@
+package consumer_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestProcessNFailedStart(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	if err := c.ProcessN(nil, 1); err == nil {
+		t.Fatal("expected ProcessN with a nil handler to fail")
+	}
+
+	// The budget of the failed ProcessN must not pause this run
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", []byte("a"), 1)
+	d.expect("FIN")
+	d.publish("2", []byte("b"), 1)
+	d.expect("FIN")
+}
+
+type rejectBody string
+
+func (r rejectBody) Validate(body []byte) error {
+	if string(body) == string(r) {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func TestProcessN(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+	c.WithValidator(rejectBody("bad"))
+
+	var handled atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ProcessN(consumer.HandlerFunc(func(*nsq.Message) error {
+			handled.Add(1)
+			return nil
+		}), 2)
+	}()
+	defer c.Stop()
+
+	// The dropped message does not count
+	d.publish("1", []byte("bad"), 1)
+	d.expect("FIN")
+	d.publish("2", []byte("good"), 1)
+	d.expect("FIN")
+
+	select {
+	case err := <-done:
+		t.Fatalf("ProcessN returned after one handled message: %v", err)
+	default:
+	}
+
+	d.publish("3", []byte("good"), 1)
+	d.expect("FIN")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("ProcessN did not return")
+	}
+	if n := handled.Load(); n != 2 {
+		t.Errorf("the handler got %d messages, want 2", n)
+	}
+	if !c.Paused() {
+		t.Error("the consumer is not paused")
+	}
+}
+
+func TestProcessNStopped(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+
+	done := make(chan error, 1)
+	go func() { done <- c.ProcessN(nopHandler, 2) }()
+
+	d.publish("1", nil, 1)
+	d.expect("FIN")
+	c.Stop()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, consumer.ErrStopped) {
+			t.Errorf("got %v, want ErrStopped", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("ProcessN did not return")
+	}
+}
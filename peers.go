@@ -0,0 +1,290 @@
+package consumer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// How often the connection counts are checked for OnConnect.
+const peerPollInterval = 100 * time.Millisecond
+
+// OnConnect registers a callback fired with the address of an nsqd
+// once the consumer is connected to it, i.e. once the first of its topics
+// is. OnDisconnect callbacks are fired once the consumer has lost
+// the last connection to an nsqd, and for each nsqd still connected
+// when Stop returns. Both are fired exactly once per transition.
+//
+// Go-nsq does not report single connections, so they are followed through
+// the lines it logs about them, confirmed by polling the connection counts:
+// a connection is reported up to 100ms after it is established. To get
+// these lines, the go-nsq clients log at the info level at least; the lines
+// below the level of the consumer logger are still dropped.
+//
+// Each callback runs in its own goroutine, so the callbacks of an nsqd
+// that connects and disconnects at once may run in any order.
+// The callbacks must be registered before Start.
+func (c *Consumer) OnConnect(fn func(addr string)) {
+	c.cbMu.Lock()
+	c.connectCbs = append(c.connectCbs, fn)
+	c.cbMu.Unlock()
+}
+
+// OnDisconnect registers a callback fired with the address of an nsqd
+// once the consumer is disconnected from it. See OnConnect for the details.
+func (c *Consumer) OnDisconnect(fn func(addr string)) {
+	c.cbMu.Lock()
+	c.disconnectCbs = append(c.disconnectCbs, fn)
+	c.cbMu.Unlock()
+}
+
+// PeerSet follows the nsqd connections of all the clients of a run
+// for the OnConnect and OnDisconnect callbacks.
+type peerSet struct {
+	connectCbs, disconnectCbs []func(addr string)
+
+	mu       sync.Mutex
+	trackers []*peerTracker
+	// The number of clients connected to each nsqd
+	conns map[string]int
+	// Incremented on every tracked line
+	seq uint64
+}
+
+// PeerTracker is the go-nsq logger of a client, following its connections
+// by the lines about them before passing the lines to the consumer logger.
+type peerTracker struct {
+	set    *peerSet
+	client *nsq.Consumer
+	log    logger
+	level  nsq.LogLevel
+
+	// Protected by set.mu
+	pending   map[string]bool
+	connected map[string]bool
+	closing   int
+}
+
+// NewPeerSet returns the peer set of a new run,
+// or nil if no callbacks are registered.
+func (c *Consumer) newPeerSet() *peerSet {
+	c.cbMu.Lock()
+	defer c.cbMu.Unlock()
+
+	if len(c.connectCbs) == 0 && len(c.disconnectCbs) == 0 {
+		return nil
+	}
+
+	return &peerSet{
+		connectCbs:    append([]func(string){}, c.connectCbs...),
+		disconnectCbs: append([]func(string){}, c.disconnectCbs...),
+		conns:         make(map[string]int),
+	}
+}
+
+// SetLogger sets the logger of a new client, tracking its connections.
+func (s *peerSet) setLogger(client *nsq.Consumer, log logger, level nsq.LogLevel) {
+	t := &peerTracker{
+		set:       s,
+		client:    client,
+		log:       log,
+		level:     level,
+		pending:   make(map[string]bool),
+		connected: make(map[string]bool),
+	}
+
+	s.mu.Lock()
+	s.trackers = append(s.trackers, t)
+	s.mu.Unlock()
+
+	if level > nsq.LogLevelInfo {
+		level = nsq.LogLevelInfo
+	}
+	client.SetLogger(t, level)
+}
+
+// Output implements the logger interface of go-nsq.
+func (t *peerTracker) Output(calldepth int, line string) error {
+	lvl, msg := parseLogLine(line)
+	t.set.observe(t, msg)
+
+	if lvl < t.level {
+		return nil
+	}
+	return t.log.Output(calldepth+1, line)
+}
+
+// Observe follows the connections of a client by a line it logged.
+//
+// Go-nsq logs "(addr) connecting to nsqd" before it dials, then either
+// "(addr) error connecting to nsqd - ..." or nothing. A connection that
+// is closed logs "(addr) clean close complete", followed by "there are
+// N connections left alive" once it is no longer counted in the stats.
+func (s *peerSet) observe(t *peerTracker, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.HasPrefix(msg, "there are ") && strings.HasSuffix(msg, " connections left alive") {
+		if t.closing > 0 {
+			t.closing--
+		}
+		s.seq++
+		return
+	}
+
+	if !strings.HasPrefix(msg, "(") {
+		return
+	}
+	addr, event, ok := strings.Cut(msg[1:], ") ")
+	if !ok {
+		return
+	}
+
+	switch {
+	case event == "connecting to nsqd":
+		t.pending[addr] = true
+	case strings.HasPrefix(event, "error connecting to nsqd"):
+		delete(t.pending, addr)
+	case event == "clean close complete":
+		t.closing++
+		// Connected and closed between two polls
+		if t.pending[addr] {
+			delete(t.pending, addr)
+			t.connected[addr] = true
+			s.up(addr)
+		}
+		if t.connected[addr] {
+			delete(t.connected, addr)
+			s.down(addr)
+		}
+	default:
+		return
+	}
+	s.seq++
+}
+
+// Failed forgets a connection of a client that go-nsq failed
+// to establish without logging it, i.e. when the error is returned
+// to the caller of ConnectToNSQD.
+func (s *peerSet) failed(client *nsq.Consumer, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.trackers {
+		if t.client == client {
+			delete(t.pending, addr)
+		}
+	}
+	s.seq++
+}
+
+// Watch confirms the pending connections until done is closed.
+func (s *peerSet) watch(clock clock, done <-chan struct{}) {
+	for {
+		select {
+		case <-clock.After(peerPollInterval):
+		case <-done:
+			return
+		}
+
+		s.mu.Lock()
+		trackers, seq := s.trackers, s.seq
+		s.mu.Unlock()
+
+		for _, t := range trackers {
+			n := t.client.Stats().Connections
+
+			s.mu.Lock()
+			// A line logged since the count was read may have changed it
+			if s.seq == seq {
+				s.confirm(t, n)
+			}
+			seq = s.seq
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Confirm marks the pending connections of a client as connected
+// once its connection count says they all are. It must be called
+// with s.mu held.
+func (s *peerSet) confirm(t *peerTracker, n int) {
+	if t.closing > 0 || len(t.pending) == 0 || n != len(t.connected)+len(t.pending) {
+		return
+	}
+
+	for _, addr := range sortedKeys(t.pending) {
+		t.connected[addr] = true
+		s.up(addr)
+	}
+	t.pending = make(map[string]bool)
+}
+
+// Flush reports the nsqds still connected as disconnected,
+// once the clients are stopped.
+func (s *peerSet) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.trackers {
+		for _, addr := range sortedKeys(t.connected) {
+			s.down(addr)
+		}
+		t.pending = make(map[string]bool)
+		t.connected = make(map[string]bool)
+	}
+}
+
+// Up and down count the clients connected to an nsqd,
+// they must be called with s.mu held.
+func (s *peerSet) up(addr string) {
+	if s.conns[addr]++; s.conns[addr] == 1 {
+		for _, fn := range s.connectCbs {
+			go fn(addr)
+		}
+	}
+}
+
+func (s *peerSet) down(addr string) {
+	if s.conns[addr]--; s.conns[addr] > 0 {
+		return
+	}
+	delete(s.conns, addr)
+	for _, fn := range s.disconnectCbs {
+		go fn(addr)
+	}
+}
+
+// ConnectNSQD connects a client to an nsqd, letting the connection
+// tracking know if it fails.
+func (c *Consumer) connectNSQD(client *nsq.Consumer, addr string) error {
+	err := client.ConnectToNSQD(addr)
+	if err != nil && err != nsq.ErrAlreadyConnected {
+		if p := c.peers.Load(); p != nil {
+			p.failed(client, addr)
+		}
+	}
+	return err
+}
+
+// ConnectNSQDs is nsq.Consumer.ConnectToNSQDs with connectNSQD.
+func (c *Consumer) connectNSQDs(client *nsq.Consumer, addrs []string) error {
+	for _, addr := range addrs {
+		if err := c.connectNSQD(client, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
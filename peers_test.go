@@ -0,0 +1,111 @@
+package consumer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// PeerEvents records the addresses passed to OnConnect and OnDisconnect.
+type peerEvents struct {
+	connected, disconnected chan string
+}
+
+func watchPeers(c *consumer.Consumer) *peerEvents {
+	e := &peerEvents{connected: make(chan string, 16), disconnected: make(chan string, 16)}
+	c.OnConnect(func(addr string) { e.connected <- addr })
+	c.OnDisconnect(func(addr string) { e.disconnected <- addr })
+	return e
+}
+
+func expectPeer(t *testing.T, ch <-chan string, want, event string) {
+	t.Helper()
+
+	select {
+	case addr := <-ch:
+		if addr != want {
+			t.Errorf("%s %s, want %s", event, addr, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("%s is not reported", event)
+	}
+}
+
+func expectNoPeer(t *testing.T, ch <-chan string, event string) {
+	t.Helper()
+
+	select {
+	case addr := <-ch:
+		t.Errorf("%s %s is reported twice", event, addr)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestOnConnectDisconnect(t *testing.T) {
+	d := newFakeNSQD(t)
+	c := newNSQDConsumer(t, d)
+	e := watchPeers(c)
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	expectPeer(t, e.connected, d.addr(), "the connection")
+	expectNoPeer(t, e.connected, "the connection")
+
+	// The nsqd goes away
+	d.close()
+	expectPeer(t, e.disconnected, d.addr(), "the lost connection")
+
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	expectNoPeer(t, e.disconnected, "the lost connection")
+}
+
+func TestOnDisconnectOnStop(t *testing.T) {
+	d := newFakeNSQD(t)
+
+	c := consumer.NewConsumer("orders", "billing").AddTopic("payments")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqd", d.addr())
+	e := watchPeers(c)
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both topics are connected to the same nsqd
+	expectPeer(t, e.connected, d.addr(), "the connection")
+	expectNoPeer(t, e.connected, "the connection")
+
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	expectPeer(t, e.disconnected, d.addr(), "the stopped connection")
+	expectNoPeer(t, e.disconnected, "the stopped connection")
+}
+
+func TestOnConnectLogLevel(t *testing.T) {
+	d := newFakeNSQD(t)
+	l := &lineLogger{}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(l, nsq.LogLevelWarning)
+	c.Set("nsqd", d.addr())
+	e := watchPeers(c)
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	expectPeer(t, e.connected, d.addr(), "the connection")
+	c.Stop()
+
+	if l.contains("connecting to nsqd") {
+		t.Error("an info line of go-nsq is logged at the warning level")
+	}
+}
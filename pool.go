@@ -0,0 +1,210 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Pool is the set of goroutines running the handlers. The go-nsq handler
+// of each topic only passes messages to it, so its size may be changed
+// while running (see SetConcurrencyLive).
+type pool struct {
+	c     *Consumer
+	jobs  chan job
+	quit  chan struct{}
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	size  int
+	start int
+
+	// Per-worker queues of the sharded mode
+	shards []chan job
+}
+
+type job struct {
+	h *wrappedHandler
+	m *nsq.Message
+}
+
+func newPool(c *Consumer, size int) *pool {
+	p := &pool{
+		c:     c,
+		jobs:  make(chan job),
+		quit:  make(chan struct{}),
+		stop:  make(chan struct{}),
+		start: size,
+	}
+
+	if c.sharded {
+		depth := c.shardQueueSize
+		if depth <= 0 {
+			depth = DefaultShardQueueSize
+		}
+		p.startShards(size, depth)
+	} else {
+		p.resize(size)
+	}
+
+	return p
+}
+
+// Resize starts or quits workers to have n of them. Quitting workers
+// finish the message they are handling first.
+func (p *pool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ; p.size < n; p.size++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	if k := p.size - n; k > 0 {
+		go func() {
+			for i := 0; i < k; i++ {
+				select {
+				case p.quit <- struct{}{}:
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+		p.size = n
+	}
+}
+
+func (p *pool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size
+}
+
+func (p *pool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case j := <-p.jobs:
+			p.handle(j)
+		case <-p.quit:
+			return
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *pool) handle(j job) {
+	if err := p.c.deliver(j.h, j.m); err != nil {
+		p.c.logf(nsq.LogLevelError, "Handler returned error (%s) for msg %s", err, j.m.ID)
+	}
+}
+
+// Close stops all workers. It must be called once go-nsq handlers
+// have exited, so that no more jobs arrive.
+func (p *pool) close() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// Dispatcher is the go-nsq handler passing messages to the pool.
+type dispatcher struct {
+	h    *wrappedHandler
+	pool *pool
+}
+
+// HandleMessage hands a copy of the message to a worker and disables the
+// auto-response of the original, so that the worker responds instead.
+// The copy is taken first, to have the auto-response of its own.
+//
+// If the pool is stopped meanwhile, which happens when go-nsq gives up
+// waiting for the handlers on stop, the message is left unanswered
+// to be redelivered by nsqd after msg_timeout.
+func (d *dispatcher) HandleMessage(m *nsq.Message) error {
+	cp := *m
+	m.DisableAutoResponse()
+
+	jobs := d.pool.jobs
+	if d.pool.shards != nil {
+		jobs = d.pool.shards[d.pool.shard(&cp)]
+	}
+
+	select {
+	case jobs <- job{h: d.h, m: &cp}:
+	case <-d.pool.stop:
+	}
+
+	return nil
+}
+
+// LogFailedMessage implements the nsq.FailedMessageLogger interface.
+// go-nsq calls it instead of HandleMessage for a message exceeded
+// max_attempts, so the message is given up right here.
+func (d *dispatcher) LogFailedMessage(m *nsq.Message) {
+	d.h.c.giveUp(d.h, m)
+}
+
+// SetConcurrencyLive changes the number of concurrent handlers of
+// a running consumer. Shrinking lets busy handlers finish their current
+// message first. Max-in-flight is scaled in step: it keeps the ratio
+// of the configured max_in_flight to the concurrency at start.
+//
+// Before Start it is the same as setting the `concurrency` option.
+func (c *Consumer) SetConcurrencyLive(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pool == nil {
+		c.concurrency = n
+		return nil
+	}
+	if c.pool.shards != nil {
+		return fmt.Errorf("concurrency cannot be changed at runtime in the sharded mode")
+	}
+
+	c.pool.resize(n)
+
+	if !c.paused {
+		for _, client := range c.clients {
+			client.ChangeMaxInFlight(c.maxInFlight())
+		}
+	}
+
+	return nil
+}
+
+// Concurrency returns the current number of concurrent handlers.
+func (c *Consumer) Concurrency() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pool == nil {
+		n, _ := c.effectiveConcurrency()
+		return n
+	}
+
+	return c.pool.len()
+}
+
+// MaxInFlight returns the configured max-in-flight scaled by the
+// current concurrency. It must be called with c.mu held.
+func (c *Consumer) maxInFlight() int {
+	if c.pool == nil || c.pool.start == 0 {
+		return c.config.MaxInFlight
+	}
+
+	n := c.config.MaxInFlight * c.pool.len() / c.pool.start
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
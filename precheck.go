@@ -0,0 +1,107 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Validate checks the configuration without opening any connections
+// and returns all problems found joined into one error:
+//
+//   - the errors of the previous Set calls;
+//   - an empty or invalid topic or channel name;
+//   - no nsqd or nsqlookupd address;
+//   - a non-positive concurrency;
+//   - a dead-letter topic without an nsqd to publish to;
+//   - the sharded mode without a shard key function;
+//   - a TLS certificate file without a key file or vice versa;
+//   - an invalid nsq.Config and the combinations described in precheck.
+//
+// It is called by Start, and may be called any number of times before it.
+func (c *Consumer) Validate() error {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	return c.validate()
+}
+
+// Validate is Validate with c.setMu held.
+func (c *Consumer) validate() error {
+	errs := c.configErrors()
+
+	for _, t := range c.allTopics() {
+		if !nsq.IsValidTopicName(t) {
+			errs = append(errs, fmt.Errorf("invalid topic name %q", t))
+		}
+	}
+	if !nsq.IsValidChannelName(c.channel) {
+		errs = append(errs, fmt.Errorf("invalid channel name %q", c.channel))
+	}
+
+	if len(c.nsqds) == 0 && len(c.nsqlookupds) == 0 && !c.discovers() {
+		errs = append(errs, fmt.Errorf(`at least one "nsqd" or "nsqlookupd" address (or a way to discover them) must be specified`))
+	}
+
+	if _, err := c.effectiveConcurrency(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.deadLetter.topic != "" && c.deadLetterAddr() == "" {
+		errs = append(errs, fmt.Errorf(`"dead_letter_topic" requires "dead_letter_nsqd" or at least one "nsqd" address`))
+	}
+
+	if err := c.checkShards(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.checkTLSFiles(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.precheck(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Precheck looks for combinations of options known to be rejected by nsqd
+// or to fail at the TLS handshake, so they are reported by Start()
+// instead of as a connection error. It checks that:
+//
+//   - deflate and snappy are not enabled together, since nsqd accepts
+//     only one compression per connection and rejects IDENTIFY otherwise;
+//   - the TLS minimum version is at least TLS 1.0, since SSL 3.0
+//     is supported neither by nsqd nor by Go;
+//   - the TLS minimum version does not exceed the maximum one.
+//
+// A tls_config without tls_v1 is not an error but a warning,
+// since the config is silently ignored by go-nsq in this case.
+func (c *Consumer) precheck() error {
+	cfg := c.config
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if cfg.Deflate && cfg.Snappy {
+		return fmt.Errorf(`"deflate" and "snappy" cannot be enabled together`)
+	}
+
+	if t := cfg.TlsConfig; t != nil {
+		if !cfg.TlsV1 {
+			c.logf(nsq.LogLevelWarning, `TLS is configured but "tls_v1" is not enabled, TLS will not be used`)
+		}
+		if t.MinVersion != 0 && t.MinVersion < tls.VersionTLS10 {
+			return fmt.Errorf("TLS minimum version %#x is not supported, TLS 1.0 at least is required", t.MinVersion)
+		}
+		if t.MinVersion != 0 && t.MaxVersion != 0 && t.MinVersion > t.MaxVersion {
+			return fmt.Errorf("TLS minimum version %#x exceeds the maximum one %#x", t.MinVersion, t.MaxVersion)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package consumer_test
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestPrecheck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options map[string]interface{}
+		err     string // empty if valid
+		warning string
+	}{
+		{
+			name:    "compressions",
+			options: map[string]interface{}{"deflate": true, "snappy": true},
+			err:     `"deflate" and "snappy" cannot be enabled together`,
+		},
+		{
+			name:    "one compression",
+			options: map[string]interface{}{"snappy": true},
+		},
+		{
+			name:    "SSL 3.0",
+			options: map[string]interface{}{"tls_v1": true, "tls_config": &tls.Config{MinVersion: tls.VersionSSL30}},
+			err:     "TLS minimum version 0x300 is not supported",
+		},
+		{
+			name:    "min over max",
+			options: map[string]interface{}{"tls_v1": true, "tls_config": &tls.Config{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}},
+			err:     "exceeds the maximum one",
+		},
+		{
+			name:    "TLS config without tls_v1",
+			options: map[string]interface{}{"tls_config": &tls.Config{MinVersion: tls.VersionTLS12}},
+			warning: `"tls_v1" is not enabled`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &lineLogger{}
+
+			c := consumer.NewConsumer("orders", "billing")
+			c.SetLogger(l, nsq.LogLevelWarning)
+			c.Set("nsqlookupd", "127.0.0.1:4161")
+			c.SetMap(tc.options)
+
+			err := c.Validate()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+			if tc.warning != "" && !l.contains(tc.warning) {
+				t.Errorf("no warning %q", tc.warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package prometheus exposes the consumer metrics to a Prometheus registry.
+//
+// The collector reports the following metrics, labelled by topic and channel:
+//
+//	nsq_consumer_messages_received_total      counter
+//	nsq_consumer_messages_finished_total      counter
+//	nsq_consumer_messages_requeued_total      counter
+//	nsq_consumer_messages_given_up_total      counter
+//	nsq_consumer_permanent_failures_total     counter
+//	nsq_consumer_transient_failures_total     counter
+//	nsq_consumer_connections                  gauge
+//	nsq_consumer_message_age_seconds          histogram
+//	nsq_consumer_handler_duration_seconds     histogram
+//	nsq_consumer_abandoned_handlers_total     counter
+//	nsq_consumer_abandoned_handlers_running   gauge
+//	nsq_consumer_compressed_messages_total    counter
+//	nsq_consumer_compressed_bytes_total       counter
+//	nsq_consumer_decompressed_bytes_total     counter
+//	nsq_consumer_uncompressed_messages_total  counter
+//	nsq_consumer_uncompressed_bytes_total     counter
+//
+// The names are the same as those written by (*consumer.Consumer).WriteMetrics.
+package prometheus
+
+import (
+	"errors"
+	"strings"
+
+	consumer "github.com/0xef53/nsq-consumer"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type collector struct {
+	c *consumer.Consumer
+
+	received    *prometheus.Desc
+	finished    *prometheus.Desc
+	requeued    *prometheus.Desc
+	givenUp     *prometheus.Desc
+	permanent   *prometheus.Desc
+	transient   *prometheus.Desc
+	connections *prometheus.Desc
+	msgAge      *prometheus.Desc
+	handlerDur  *prometheus.Desc
+
+	abandoned        *prometheus.Desc
+	abandonedRunning *prometheus.Desc
+	compressedMsgs   *prometheus.Desc
+	compressedBytes  *prometheus.Desc
+	decompressed     *prometheus.Desc
+	uncompressedMsgs *prometheus.Desc
+	uncompressedSize *prometheus.Desc
+}
+
+// NewCollector returns a prometheus.Collector of a given consumer.
+// It may be created before the consumer is started, but after its topics
+// and channel are set: they are constant labels of the metrics, so that
+// the collectors of several consumers can share a registry.
+func NewCollector(c *consumer.Consumer) prometheus.Collector {
+	labels := prometheus.Labels{
+		"topic":   strings.Join(c.Topics(), ","),
+		"channel": c.Channel(),
+	}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, nil, labels)
+	}
+
+	return &collector{
+		c:           c,
+		received:    desc("nsq_consumer_messages_received_total", "Total number of messages received."),
+		finished:    desc("nsq_consumer_messages_finished_total", "Total number of messages finished."),
+		requeued:    desc("nsq_consumer_messages_requeued_total", "Total number of messages requeued."),
+		givenUp:     desc("nsq_consumer_messages_given_up_total", "Total number of messages that exceeded max_attempts."),
+		permanent:   desc("nsq_consumer_permanent_failures_total", "Total number of permanent handler errors."),
+		transient:   desc("nsq_consumer_transient_failures_total", "Total number of transient handler errors."),
+		connections: desc("nsq_consumer_connections", "Current number of nsqd connections."),
+		msgAge:      desc("nsq_consumer_message_age_seconds", "Age of messages at processing time."),
+		handlerDur:  desc("nsq_consumer_handler_duration_seconds", "Time spent in the handler per message."),
+
+		abandoned:        desc("nsq_consumer_abandoned_handlers_total", "Total number of handlers abandoned on timeout."),
+		abandonedRunning: desc("nsq_consumer_abandoned_handlers_running", "Current number of abandoned handlers still running."),
+		compressedMsgs:   desc("nsq_consumer_compressed_messages_total", "Total number of messages with gzip bodies."),
+		compressedBytes:  desc("nsq_consumer_compressed_bytes_total", "Total size of gzip bodies."),
+		decompressed:     desc("nsq_consumer_decompressed_bytes_total", "Total size of decompressed bodies."),
+		uncompressedMsgs: desc("nsq_consumer_uncompressed_messages_total", "Total number of messages with plain bodies."),
+		uncompressedSize: desc("nsq_consumer_uncompressed_bytes_total", "Total size of plain bodies."),
+	}
+}
+
+// Register registers a collector of a given consumer on reg.
+// Registering the same consumer twice is not an error, but registering
+// another consumer of the same topics and channel is.
+func Register(reg prometheus.Registerer, c *consumer.Consumer) error {
+	err := reg.Register(NewCollector(c))
+
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if x, ok := are.ExistingCollector.(*collector); ok && x.c == c {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Describe implements the prometheus.Collector interface.
+func (x *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- x.received
+	ch <- x.finished
+	ch <- x.requeued
+	ch <- x.givenUp
+	ch <- x.permanent
+	ch <- x.transient
+	ch <- x.connections
+	ch <- x.msgAge
+	ch <- x.handlerDur
+	ch <- x.abandoned
+	ch <- x.abandonedRunning
+	ch <- x.compressedMsgs
+	ch <- x.compressedBytes
+	ch <- x.decompressed
+	ch <- x.uncompressedMsgs
+	ch <- x.uncompressedSize
+}
+
+// Collect implements the prometheus.Collector interface.
+func (x *collector) Collect(ch chan<- prometheus.Metric) {
+	stats := x.c.Stats()
+
+	counter := func(desc *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v))
+	}
+	gauge := func(desc *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v))
+	}
+
+	counter(x.received, stats.MessagesReceived)
+	counter(x.finished, stats.MessagesFinished)
+	counter(x.requeued, stats.MessagesRequeued)
+	counter(x.givenUp, x.c.GivenUp())
+	permanent, transient := x.c.Failures()
+	counter(x.permanent, permanent)
+	counter(x.transient, transient)
+	gauge(x.connections, uint64(stats.Connections))
+
+	ch <- histogram(x.msgAge, x.c.MessageAge())
+	ch <- histogram(x.handlerDur, x.c.HandlerDuration())
+
+	total, running := x.c.AbandonedHandlers()
+	counter(x.abandoned, total)
+	gauge(x.abandonedRunning, running)
+
+	body := x.c.BodyStats()
+	counter(x.compressedMsgs, body.CompressedMessages)
+	counter(x.compressedBytes, body.CompressedBytes)
+	counter(x.decompressed, body.DecompressedBytes)
+	counter(x.uncompressedMsgs, body.UncompressedMessages)
+	counter(x.uncompressedSize, body.UncompressedBytes)
+}
+
+func histogram(desc *prometheus.Desc, h consumer.HistogramSnapshot) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Buckets))
+	for i, b := range h.Buckets {
+		buckets[b.Seconds()] = h.Counts[i]
+	}
+
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum.Seconds(), buckets)
+}
@@ -0,0 +1,77 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	consumer "github.com/0xef53/nsq-consumer"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterSeveralConsumers(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	a := consumer.NewConsumer("orders", "billing")
+	b := consumer.NewConsumer("orders", "shipping")
+
+	if err := Register(reg, a); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if err := Register(reg, b); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	if err := Register(reg, a); err != nil {
+		t.Fatalf("register a twice: %v", err)
+	}
+
+	n, err := testutil.GatherAndCount(reg, "nsq_consumer_messages_received_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d series, want 2", n)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	if err := Register(reg, consumer.NewConsumer("orders", "billing")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(reg, consumer.NewConsumer("orders", "billing")); err == nil {
+		t.Fatal("expected an error for another consumer of the same topic and channel")
+	}
+}
+
+func TestMetricNames(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+
+	var buf strings.Builder
+	c.WriteMetrics(&buf)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := Register(reg, c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	for _, f := range families {
+		got[f.GetName()] = true
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name := strings.Fields(line)[2]
+		if !got[name] {
+			t.Errorf("%s is written by WriteMetrics but not collected", name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package protobuf provides handlers of protobuf-encoded messages.
+//
+// It lives in its own package so that the protobuf module is
+// pulled only by those who use it.
+package protobuf
+
+import (
+	"github.com/nsqio/go-nsq"
+	"google.golang.org/protobuf/proto"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// Start starts the consumer with a handler that unmarshals each message body
+// into a new message returned by newMsg and passes it to fn.
+// NewMsg is called per message, so handlers never share a value.
+//
+// Undecodable messages are handled by the bad message policy
+// of the consumer (see consumer.SetBadMessagePolicy).
+func Start(c *consumer.Consumer, newMsg func() proto.Message, fn func(*nsq.Message, proto.Message) error) error {
+	return c.Start(Handler(c, newMsg, fn))
+}
+
+// Handler returns the handler used by Start, e.g. to be wrapped by the caller.
+func Handler(c *consumer.Consumer, newMsg func() proto.Message, fn func(*nsq.Message, proto.Message) error) nsq.Handler {
+	return consumer.HandlerFunc(func(m *nsq.Message) error {
+		msg := newMsg()
+		if err := proto.Unmarshal(m.Body, msg); err != nil {
+			return c.HandleBadMessage(m, err)
+		}
+		return fn(m, msg)
+	})
+}
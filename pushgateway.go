@@ -0,0 +1,135 @@
+package consumer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// WithPushGateway makes the consumer push its metrics to a Prometheus
+// Pushgateway at a given URL when it stops, so that short-lived batch
+// consumers do not lose them. With the `push_interval` option the metrics
+// are also pushed periodically while consuming.
+//
+// The metrics are pushed with PUT, replacing the previous ones of the group,
+// to /metrics/job/<job>/topic/<topic>/channel/<channel>. So the grouping
+// labels are job, topic and channel. See WriteMetrics for the metric names.
+func (c *Consumer) WithPushGateway(url, job string) *Consumer {
+	c.pushURL = url
+	c.pushJob = job
+	return c
+}
+
+func (c *Consumer) pushLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-c.clock.After(c.pushInterval):
+		case <-done:
+			return
+		}
+		if err := c.push(); err != nil {
+			c.logf(nsq.LogLevelError, "%v", err)
+		}
+	}
+}
+
+func (c *Consumer) push() error {
+	var buf bytes.Buffer
+	c.WriteMetrics(&buf)
+
+	u := fmt.Sprintf("%s/metrics/job/%s/topic/%s/channel/%s",
+		strings.TrimRight(c.pushURL, "/"),
+		url.PathEscape(c.pushJob), url.PathEscape(c.topicLabel()), url.PathEscape(c.channel))
+
+	req, err := http.NewRequest(http.MethodPut, u, &buf)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// WriteMetrics writes the consumer metrics in the Prometheus text format:
+//
+//	nsq_consumer_messages_received_total      counter
+//	nsq_consumer_messages_finished_total      counter
+//	nsq_consumer_messages_requeued_total      counter
+//	nsq_consumer_messages_given_up_total      counter
+//	nsq_consumer_permanent_failures_total     counter
+//	nsq_consumer_transient_failures_total     counter
+//	nsq_consumer_connections                  gauge
+//	nsq_consumer_message_age_seconds          histogram
+//	nsq_consumer_handler_duration_seconds     histogram
+//	nsq_consumer_abandoned_handlers_total     counter
+//	nsq_consumer_abandoned_handlers_running   gauge
+//	nsq_consumer_compressed_messages_total    counter
+//	nsq_consumer_compressed_bytes_total       counter
+//	nsq_consumer_decompressed_bytes_total     counter
+//	nsq_consumer_uncompressed_messages_total  counter
+//	nsq_consumer_uncompressed_bytes_total     counter
+//
+// Every metric is labelled by topic and channel.
+func (c *Consumer) WriteMetrics(w io.Writer) {
+	labels := fmt.Sprintf(`topic=%q,channel=%q`, c.topicLabel(), c.channel)
+
+	metric := func(name, typ string, value interface{}) {
+		fmt.Fprintf(w, "# TYPE %s %s\n%s{%s} %v\n", name, typ, name, labels, value)
+	}
+
+	c.mu.Lock()
+	stats := sumStats(c.clients)
+	c.mu.Unlock()
+
+	metric("nsq_consumer_messages_received_total", "counter", stats.MessagesReceived)
+	metric("nsq_consumer_messages_finished_total", "counter", stats.MessagesFinished)
+	metric("nsq_consumer_messages_requeued_total", "counter", stats.MessagesRequeued)
+	metric("nsq_consumer_messages_given_up_total", "counter", c.GivenUp())
+
+	permanent, transient := c.Failures()
+	metric("nsq_consumer_permanent_failures_total", "counter", permanent)
+	metric("nsq_consumer_transient_failures_total", "counter", transient)
+	metric("nsq_consumer_connections", "gauge", stats.Connections)
+
+	histogram := func(name string, h HistogramSnapshot) {
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for i, b := range h.Buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, b.Seconds(), h.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.Sum.Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count)
+	}
+
+	histogram("nsq_consumer_message_age_seconds", c.MessageAge())
+	histogram("nsq_consumer_handler_duration_seconds", c.HandlerDuration())
+
+	total, running := c.AbandonedHandlers()
+	metric("nsq_consumer_abandoned_handlers_total", "counter", total)
+	metric("nsq_consumer_abandoned_handlers_running", "gauge", running)
+
+	body := c.BodyStats()
+	metric("nsq_consumer_compressed_messages_total", "counter", body.CompressedMessages)
+	metric("nsq_consumer_compressed_bytes_total", "counter", body.CompressedBytes)
+	metric("nsq_consumer_decompressed_bytes_total", "counter", body.DecompressedBytes)
+	metric("nsq_consumer_uncompressed_messages_total", "counter", body.UncompressedMessages)
+	metric("nsq_consumer_uncompressed_bytes_total", "counter", body.UncompressedBytes)
+}
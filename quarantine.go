@@ -0,0 +1,64 @@
+package consumer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// WithQuarantineDir makes the consumer save messages that exceeded
+// max_attempts into a given directory before go-nsq discards them,
+// so that poison messages can be inspected and replayed manually.
+//
+// Each message is written to its own file named <message id>.json
+// containing the Envelope encoded as JSON (see Envelope for the shape;
+// the custom envelope builder is not used, to keep the format stable).
+// The original body is in the base64 "body" field. Files are written
+// atomically, so a file in the directory is always complete.
+//
+// If the message cannot be written, the error is logged
+// and the message is dropped as it would be without quarantine.
+func (c *Consumer) WithQuarantineDir(path string) *Consumer {
+	c.quarantineDir = path
+	return c
+}
+
+func (c *Consumer) quarantine(topic string, m *nsq.Message, last lastError) {
+	if c.quarantineDir == "" {
+		return
+	}
+
+	if err := c.writeQuarantine(topic, m, last); err != nil {
+		c.logMsgf(m, nsq.LogLevelError, "failed to quarantine %s, dropping: %v", MessageInfo(m), err)
+	}
+}
+
+func (c *Consumer) writeQuarantine(topic string, m *nsq.Message, last lastError) error {
+	info := MessageInfo(m)
+
+	data, err := DefaultEnvelopeBuilder(c.newEnvelope(topic, m, last))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.quarantineDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.quarantineDir, ".tmp-"+info.ID+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(c.quarantineDir, info.ID+".json"))
+}
@@ -0,0 +1,106 @@
+package consumer_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+func TestQuarantineEnvelope(t *testing.T) {
+	dir := t.TempDir()
+
+	c := consumer.NewConsumer("orders", "billing").WithQuarantineDir(dir)
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("max_attempts", 2)
+
+	h := consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("bad order") })
+
+	var id nsq.MessageID
+	copy(id[:], "0123456789abcdef")
+	m := nsq.NewMessage(id, []byte("payload"))
+
+	for attempts := uint16(1); attempts <= 3; attempts++ {
+		m.Attempts = attempts
+		c.Deliver(h, m)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0123456789abcdef.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e consumer.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Topic != "orders" || e.Channel != "billing" || e.Attempts != 3 {
+		t.Errorf("envelope = %+v", e)
+	}
+	if e.Error != "bad order" {
+		t.Errorf("error = %q, want %q", e.Error, "bad order")
+	}
+	if string(e.Body) != "payload" {
+		t.Errorf("body = %q", e.Body)
+	}
+}
+
+func TestQuarantineTraceParent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	dir := t.TempDir()
+
+	c := consumer.NewConsumer("orders", "billing").WithQuarantineDir(dir)
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("max_attempts", 1)
+	c.Use(func(next nsq.Handler) nsq.Handler {
+		return consumer.HandlerFunc(func(m *nsq.Message) error {
+			consumer.SetContext(m, consumer.WithTraceParent(consumer.Context(m), traceparent))
+			return next.HandleMessage(m)
+		})
+	})
+
+	h := consumer.HandlerFunc(func(*nsq.Message) error { return errors.New("bad order") })
+
+	var id nsq.MessageID
+	copy(id[:], "0123456789abcdef")
+	m := nsq.NewMessage(id, []byte("payload"))
+
+	for attempts := uint16(1); attempts <= 2; attempts++ {
+		m.Attempts = attempts
+		c.Deliver(h, m)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0123456789abcdef.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e consumer.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.TraceParent != traceparent {
+		t.Errorf("traceparent = %q, want %q", e.TraceParent, traceparent)
+	}
+
+	// Omitted without a traced attempt
+	e.TraceParent = ""
+	data, err = consumer.DefaultEnvelopeBuilder(&e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["traceparent"]; ok {
+		t.Errorf("an empty traceparent is encoded: %s", data)
+	}
+}
@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// SetRateLimit limits the number of messages passed to the handler per
+// second, shared by all the handlers of the consumer, with bursts of up to
+// burst messages (at least 1). A zero or negative rate means no limit.
+//
+// It sets both the `max_rate` and `max_rate_burst` options at once.
+// Like them, it may be called after Start and takes effect immediately.
+// The handlers wait for their turn after the message is received, so the
+// waiting time counts towards msg_timeout.
+func (c *Consumer) SetRateLimit(rps float64, burst int) {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	c.rateRPS, c.rateBurst = rps, burst
+	c.applyRateLimit()
+}
+
+// ApplyRateLimit replaces the limiter with the one of the `max_rate`
+// and `max_rate_burst` options. It must be called with c.setMu held.
+func (c *Consumer) applyRateLimit() {
+	if c.rateRPS <= 0 {
+		c.rateLimit.Store(nil)
+		return
+	}
+	c.rateLimit.Store(newTokenBucket(c.rateRPS, c.rateBurst, c.clock.Now()))
+}
+
+// TokenBucket is a rate limiter filled with rate tokens per second,
+// holding up to burst ones.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Wait takes a token, waiting until one is available or ctx is done.
+// It returns false in the latter case.
+func (b *tokenBucket) wait(ctx context.Context, cl clock) bool {
+	for {
+		b.mu.Lock()
+		now := cl.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return true
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-cl.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// RateLimited waits for the rate limit, if any. It returns false if the
+// message must not be handled; such a message is already requeued.
+func (h *wrappedHandler) rateLimited(ctx context.Context, m *nsq.Message) bool {
+	b := h.c.rateLimit.Load()
+	if b == nil || b.wait(ctx, h.c.clock) {
+		return true
+	}
+
+	m.DisableAutoResponse()
+	m.RequeueWithoutBackoff(0)
+
+	return false
+}
+
+func float(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("expected number")
+	}
+}
@@ -0,0 +1,47 @@
+package consumer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestSetRateLimitThenSet(t *testing.T) {
+	clk := newFakeClock()
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+
+	c.SetRateLimit(100, 10)
+	c.Set("max_rate_burst", 20)
+
+	cfg := c.ConfigMap()
+	if cfg["max_rate"] != float64(100) || cfg["max_rate_burst"] != 20 {
+		t.Fatalf("max_rate = %v, max_rate_burst = %v, want 100 and 20", cfg["max_rate"], cfg["max_rate_burst"])
+	}
+
+	// The burst of 20 passes at once, the next message waits for a token
+	msgs := make([]*nsq.Message, 21)
+	for i := range msgs {
+		msgs[i] = consumertest.NewMessage([]byte{byte(i)}, nil)
+	}
+	done := deliverAsync(c, nopHandler, msgs...)
+
+	clk.waitTimers(t, 1)
+	clk.advance(10 * time.Millisecond)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("more than one message waited for a token")
+	}
+
+	c.SetRateLimit(0, 0)
+	if cfg := c.ConfigMap(); cfg["max_rate"] != float64(0) {
+		t.Errorf("max_rate = %v after removing the limit", cfg["max_rate"])
+	}
+}
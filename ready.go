@@ -0,0 +1,71 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// How often the connection count is checked until the consumer is ready.
+const readyPollInterval = 100 * time.Millisecond
+
+// OnReady registers a callback fired exactly once when the consumer
+// becomes ready, i.e. when the first connection to an nsqd is established
+// and subscribed, so messages can be received. For a multi-topic consumer
+// it is the first connection of any topic: the others may still be
+// connecting, or never connect if their nsqds are unreachable.
+//
+// If the consumer is already ready, the callback is called immediately.
+func (c *Consumer) OnReady(fn func()) {
+	c.cbMu.Lock()
+	if !c.isReady {
+		c.readyCbs = append(c.readyCbs, fn)
+		c.cbMu.Unlock()
+		return
+	}
+	c.cbMu.Unlock()
+
+	fn()
+}
+
+func (c *Consumer) markReady() {
+	c.cbMu.Lock()
+	if c.isReady {
+		c.cbMu.Unlock()
+		return
+	}
+	c.isReady = true
+	cbs := c.readyCbs
+	c.readyCbs = nil
+	c.cbMu.Unlock()
+
+	for _, fn := range cbs {
+		fn()
+	}
+}
+
+// WatchReady polls the connection count of the given clients
+// until one of them is connected to an nsqd or done is closed.
+func (c *Consumer) watchReady(clients []*nsq.Consumer, done <-chan struct{}) {
+	for {
+		if anyConnected(clients) {
+			c.markReady()
+			return
+		}
+
+		select {
+		case <-c.clock.After(readyPollInterval):
+		case <-done:
+			return
+		}
+	}
+}
+
+func anyConnected(clients []*nsq.Consumer) bool {
+	for _, client := range clients {
+		if client.Stats().Connections > 0 {
+			return true
+		}
+	}
+	return false
+}
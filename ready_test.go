@@ -0,0 +1,58 @@
+package consumer_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// TopicLookupd starts an nsqlookupd HTTP API knowing a given nsqd
+// for the given topics only, and returns its address.
+func topicLookupd(t *testing.T, nsqd string, topics ...string) string {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(nsqd)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		producers := ""
+		for _, topic := range topics {
+			if r.URL.Query().Get("topic") == topic {
+				producers = fmt.Sprintf(`{"broadcast_address":%q,"tcp_port":%s}`, host, port)
+			}
+		}
+		w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+		fmt.Fprintf(w, `{"channels":[],"producers":[%s]}`, producers)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestOnReadyMultiTopic(t *testing.T) {
+	d := newFakeNSQD(t)
+
+	c := consumer.NewConsumer("orders", "billing").AddTopic("payments")
+	c.SetLogger(nil, nsq.LogLevelError)
+	// Nothing is known about the payments topic
+	c.Set("nsqlookupd", topicLookupd(t, d.addr(), "orders"))
+
+	ready := make(chan struct{})
+	c.OnReady(func() { close(ready) })
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	wait(t, ready, "the consumer to be ready")
+
+	if c.IsHealthy() {
+		t.Error("the consumer is healthy without a connection for payments")
+	}
+}
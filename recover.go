@@ -0,0 +1,62 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// PanicError is the error a panic of the handler is turned into by Recoverer.
+type PanicError struct {
+	Recovered interface{}
+	Stack     []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Recovered)
+}
+
+// Recoverer returns a middleware turning a panic of the next handler into
+// a *PanicError, so that the message is requeued as failed instead of
+// crashing the process. The consumer logs such errors with the stack
+// trace and passes them to the OnPanic callbacks.
+//
+// The `recover_panics` option applies it around all the other middlewares.
+func Recoverer() Middleware {
+	return func(next nsq.Handler) nsq.Handler {
+		return HandlerFunc(func(m *nsq.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Recovered: r, Stack: debug.Stack()}
+				}
+			}()
+			return next.HandleMessage(m)
+		})
+	}
+}
+
+// OnPanic registers a callback receiving the panics recovered by Recoverer,
+// e.g. to report them to an error tracker. It is called synchronously
+// in the handler goroutine; a panic in the callback itself is recovered
+// and logged. It must be registered before Start().
+func (c *Consumer) OnPanic(fn func(m *nsq.Message, recovered interface{}, stack []byte)) {
+	c.panicCbs = append(c.panicCbs, fn)
+}
+
+// ReportPanic logs a recovered panic, if err is one,
+// and passes it to the OnPanic callbacks.
+func (c *Consumer) reportPanic(m *nsq.Message, err error) {
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		return
+	}
+
+	c.logMsgf(m, nsq.LogLevelError, "handler panicked on %s: %v\n%s", MessageInfo(m), pe.Recovered, pe.Stack)
+	c.countPanic()
+
+	for _, fn := range c.panicCbs {
+		c.callHook(m, func() { fn(m, pe.Recovered, pe.Stack) })
+	}
+}
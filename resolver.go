@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Resolver discovers nsqd and nsqlookupd addresses, e.g. in a service
+// registry. The addresses are connected to in addition to the configured
+// ones and checked the same way.
+//
+// The consumer calls Resolve at start, where an error or an empty result
+// makes Start fail, and then every refresh interval given to SetResolver.
+// A failed or empty refresh is logged and the existing connections are kept.
+// Otherwise the clients are connected to the new addresses and disconnected
+// from the ones that are gone.
+type Resolver interface {
+	Resolve(ctx context.Context) (nsqds []string, nsqlookupds []string, err error)
+}
+
+// SetResolver sets a Resolver of additional addresses, the `resolver` option,
+// and the interval of its refresh, the `discovery_interval` option
+// (0 means resolving only at start). The interval is shared with
+// the `nsqd_srv` and `nsqlookupd_srv` records.
+func (c *Consumer) SetResolver(r Resolver, refresh time.Duration) {
+	c.Set("resolver", r)
+	c.Set("discovery_interval", refresh)
+}
+
+// StaticResolver is a Resolver of fixed lists of addresses.
+type StaticResolver struct {
+	NSQDs       []string
+	NSQLookupds []string
+}
+
+// Resolve implements the Resolver interface.
+func (r *StaticResolver) Resolve(context.Context) ([]string, []string, error) {
+	return r.NSQDs, r.NSQLookupds, nil
+}
+
+// HTTPResolver is a Resolver that fetches the addresses from a JSON
+// endpoint responding with an object of the following form:
+//
+//	{"nsqds": ["nsqd-1:4150"], "nsqlookupds": ["http://lookupd-1:4161"]}
+type HTTPResolver struct {
+	URL string
+
+	// Client is used for the requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve implements the Resolver interface.
+func (r *HTTPResolver) Resolve(ctx context.Context) ([]string, []string, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: unexpected status %s", r.URL, resp.Status)
+	}
+
+	var res struct {
+		NSQDs       []string `json:"nsqds"`
+		NSQLookupds []string `json:"nsqlookupds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", r.URL, err)
+	}
+
+	return res.NSQDs, res.NSQLookupds, nil
+}
@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// ResultCache records the keys of successfully processed messages.
+// Implementations must be safe for concurrent use.
+type ResultCache interface {
+	// Has reports whether the key has been recorded and has not expired yet.
+	Has(key string) bool
+	// Put records the key for a given time to live.
+	Put(key string, ttl time.Duration)
+}
+
+// WithResultCache registers a middleware for idempotent handlers doing
+// expensive work: once a message has been handled successfully, its key is
+// recorded in the cache for ttl, and a redelivered message with the same key
+// is finished without calling the handler again.
+//
+// Unlike deduplication, only successes are recorded, so a failed message
+// is retried until it succeeds. The key defaults to the message ID if keyFn
+// is nil. See MemoryResultCache for an in-memory cache.
+func (c *Consumer) WithResultCache(cache ResultCache, keyFn func(*nsq.Message) string, ttl time.Duration) *Consumer {
+	c.UseNamed("consumer.ResultCache", resultCacheMiddleware(cache, keyFn, ttl, nil))
+	return c
+}
+
+// ResultCacheMiddleware finishes a message whose key is in the cache
+// without calling the next handler, calling hit if not nil, and records
+// the key of a successfully handled one for ttl.
+func resultCacheMiddleware(cache ResultCache, keyFn func(*nsq.Message) string, ttl time.Duration, hit func()) Middleware {
+	if keyFn == nil {
+		keyFn = func(m *nsq.Message) string { return string(m.ID[:]) }
+	}
+
+	return func(next nsq.Handler) nsq.Handler {
+		return HandlerFunc(func(m *nsq.Message) error {
+			key := keyFn(m)
+			if cache.Has(key) {
+				if hit != nil {
+					hit()
+				}
+				return nil
+			}
+
+			if err := next.HandleMessage(m); err != nil {
+				return err
+			}
+			cache.Put(key, ttl)
+
+			return nil
+		})
+	}
+}
+
+// MemoryResultCache is an in-memory ResultCache. Expired keys are purged
+// lazily, so its size is bounded by the number of keys put within the TTL.
+type MemoryResultCache struct {
+	// Maximum number of keys, 0 for no limit
+	size int
+
+	mu        sync.Mutex
+	lru       *list.List // of *cacheEntry, the most recent first
+	index     map[string]*list.Element
+	lastPurge time.Time
+	clock     clock
+}
+
+type cacheEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryResultCache returns a new empty in-memory cache.
+func NewMemoryResultCache() *MemoryResultCache {
+	return newMemoryResultCache(0)
+}
+
+// NewMemoryResultCache returns a new empty in-memory cache of at most
+// size keys (no limit if 0), the least recently seen are forgotten first.
+func newMemoryResultCache(size int) *MemoryResultCache {
+	return &MemoryResultCache{
+		size:  size,
+		lru:   list.New(),
+		index: make(map[string]*list.Element),
+		clock: realClock{},
+	}
+}
+
+// Has implements the ResultCache interface.
+func (r *MemoryResultCache) Has(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	el, ok := r.index[key]
+	if !ok {
+		return false
+	}
+	if !r.clock.Now().Before(el.Value.(*cacheEntry).expires) {
+		r.remove(el)
+		return false
+	}
+	r.lru.MoveToFront(el)
+
+	return true
+}
+
+// Put implements the ResultCache interface.
+func (r *MemoryResultCache) Put(key string, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	expires := now.Add(ttl)
+
+	if el, ok := r.index[key]; ok {
+		el.Value.(*cacheEntry).expires = expires
+		r.lru.MoveToFront(el)
+	} else {
+		r.index[key] = r.lru.PushFront(&cacheEntry{key: key, expires: expires})
+	}
+
+	for r.size > 0 && r.lru.Len() > r.size {
+		r.remove(r.lru.Back())
+	}
+
+	// Purge the expired keys at most once per TTL
+	if now.Sub(r.lastPurge) >= ttl {
+		for el := r.lru.Front(); el != nil; {
+			next := el.Next()
+			if !now.Before(el.Value.(*cacheEntry).expires) {
+				r.remove(el)
+			}
+			el = next
+		}
+		r.lastPurge = now
+	}
+}
+
+func (r *MemoryResultCache) remove(el *list.Element) {
+	r.lru.Remove(el)
+	delete(r.index, el.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,48 @@
+package consumer
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// ErrForcedShutdown is returned by Run if a second signal arrives
+// while the consumer is stopping. The handlers may still be running.
+var ErrForcedShutdown = errors.New("forced shutdown")
+
+// Run starts the consumer with a given handler, blocks until one of given
+// signals (SIGINT and SIGTERM by default) arrives or the consumer stops
+// on its own, and stops it gracefully. It returns the first error of Start
+// and Stop, or ErrForcedShutdown on a second signal during the stop.
+func (c *Consumer) Run(handler nsq.Handler, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, signals...)
+	defer signal.Stop(sigs)
+
+	if err := c.Start(handler); err != nil {
+		return err
+	}
+
+	select {
+	case sig := <-sigs:
+		c.logf(nsq.LogLevelInfo, "received %s, stopping", sig)
+	case <-c.done:
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Stop() }()
+
+	select {
+	case err := <-stopped:
+		return err
+	case <-sigs:
+		return ErrForcedShutdown
+	}
+}
@@ -0,0 +1,111 @@
+package consumer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// SetStruct sets options from the exported fields of a struct (or a pointer
+// to one), e.g. a part of the service config decoded from a file:
+//
+//	type Config struct {
+//		Topic       string        `nsq:"topic"`
+//		Lookupds    []string      `nsq:"nsqlookupds"`
+//		Concurrency int           // concurrency
+//		MaxInFlight int           // max_in_flight
+//		MsgTimeout  time.Duration `nsq:"msg_timeout"`
+//		TLS         bool          `nsq:"tls_v1,always"`
+//		Internal    string        `nsq:"-"`
+//	}
+//
+// The option name is taken from the `nsq` tag, or is the snake-cased field
+// name (prefer tags for names with acronyms). Fields tagged "-" are skipped,
+// and so are fields of zero values unless tagged with the `always` flag.
+// Embedded structs without a tag are walked into.
+//
+// Integers become int; other values, including strings of numbers,
+// are passed to Set as they are. Options unknown to the wrapper are
+// passed through to nsq.Config, as with Set. Errors are returned by Start()
+// with the name of the field.
+func (c *Consumer) SetStruct(v interface{}) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		c.fail(fmt.Errorf("SetStruct: expected struct, got %T", v))
+		return
+	}
+
+	c.setStruct(rv)
+}
+
+func (c *Consumer) setStruct(rv reflect.Value) {
+	t := rv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), rv.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag, hasTag := f.Tag.Lookup("nsq")
+		name, flags, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && !hasTag && fv.Kind() == reflect.Struct {
+			c.setStruct(fv)
+			continue
+		}
+		if name == "" {
+			name = snakeCase(f.Name)
+		}
+
+		if fv.IsZero() && flags != "always" {
+			continue
+		}
+
+		if err := c.trySet(name, structValue(fv)); err != nil {
+			c.fail(fmt.Errorf("field %s: %v", f.Name, err))
+		}
+	}
+}
+
+// StructValue converts a field value to the type Set expects.
+func structValue(fv reflect.Value) interface{} {
+	switch {
+	case fv.Type() == durationType:
+		return time.Duration(fv.Int())
+	case fv.Kind() >= reflect.Int && fv.Kind() <= reflect.Int64:
+		return int(fv.Int())
+	case fv.Kind() >= reflect.Uint && fv.Kind() <= reflect.Uint64:
+		return int(fv.Uint())
+	}
+	return fv.Interface()
+}
+
+// SnakeCase turns MaxInFlight into max_in_flight and HTTPClient
+// into http_client.
+func snakeCase(s string) string {
+	r := []rune(s)
+
+	var b strings.Builder
+	for i, ch := range r {
+		if unicode.IsUpper(ch) {
+			prevLower := i > 0 && !unicode.IsUpper(r[i-1])
+			nextLower := i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) && unicode.IsUpper(r[i-1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+			ch = unicode.ToLower(ch)
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
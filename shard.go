@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DefaultShardQueueSize is the number of messages a shard may queue
+// unless the `shard_queue_size` option is set.
+const DefaultShardQueueSize = 16
+
+// SetShardKeyFunc enables the sharded mode: every message goes to one of
+// `concurrency` handler goroutines chosen by the hash of its key, and each
+// of them handles its messages one by one in the order they arrived.
+// So messages of the same key are handled in order, while messages of
+// different keys are handled in parallel.
+//
+// NSQ itself does not guarantee the order of delivery, and a requeued
+// message comes back later, so the order is that of arrival at the consumer.
+//
+// Each shard queues up to `shard_queue_size` messages; when a queue is full,
+// receiving stops until it has room. Stop waits for the queued messages
+// to be handled. The concurrency cannot be changed at runtime in this mode.
+func (c *Consumer) SetShardKeyFunc(fn func(m *nsq.Message) string) {
+	c.shardKey = fn
+	c.Set("sharded", fn != nil)
+}
+
+// Shard returns the index of the shard of a given message.
+func (p *pool) shard(m *nsq.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(p.c.shardKey(m)))
+	return int(h.Sum32() % uint32(len(p.shards)))
+}
+
+func (p *pool) startShards(n, depth int) {
+	p.shards = make([]chan job, n)
+	for i := range p.shards {
+		p.shards[i] = make(chan job, depth)
+		p.wg.Add(1)
+		go p.workShard(p.shards[i])
+	}
+	p.size = n
+}
+
+// WorkShard handles the messages of a shard in order. Once the pool is
+// stopped, it handles the rest of the queue before returning.
+func (p *pool) workShard(jobs chan job) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case j := <-jobs:
+			p.handle(j)
+		case <-p.stop:
+			for {
+				select {
+				case j := <-jobs:
+					p.handle(j)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Consumer) checkShards() error {
+	if c.sharded && c.shardKey == nil {
+		return fmt.Errorf(`"sharded" requires a shard key function (see SetShardKeyFunc)`)
+	}
+	return nil
+}
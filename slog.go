@@ -0,0 +1,94 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// SetSlogLogger replaces the logger with a given slog.Logger. The level
+// of each line, written by go-nsq or by the consumer, is translated
+// into the slog one, and the topic and channel are attached as attributes.
+//
+// The go-nsq level is set to the lowest one enabled in the slog handler.
+func (c *Consumer) SetSlogLogger(l *slog.Logger) {
+	if l == nil {
+		c.SetLogger(nil, c.level)
+		return
+	}
+
+	level := nsq.LogLevelError
+	for _, lvl := range []nsq.LogLevel{nsq.LogLevelWarning, nsq.LogLevelInfo, nsq.LogLevelDebug} {
+		if l.Enabled(context.Background(), slogLevel(lvl)) {
+			level = lvl
+		}
+	}
+
+	c.SetLogger(&slogLogger{c: c, l: l}, level)
+}
+
+type slogLogger struct {
+	c *Consumer
+	l *slog.Logger
+}
+
+func (s *slogLogger) Output(_ int, line string) error {
+	lvl, msg := parseLogLine(line)
+	s.l.Log(context.Background(), slogLevel(lvl), msg,
+		slog.String("topic", s.c.topicLabel()), slog.String("channel", s.c.channel))
+	return nil
+}
+
+func slogLevel(lvl nsq.LogLevel) slog.Level {
+	switch lvl {
+	case nsq.LogLevelDebug:
+		return slog.LevelDebug
+	case nsq.LogLevelWarning:
+		return slog.LevelWarn
+	case nsq.LogLevelError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// ParseLogLine splits a line written by go-nsq ("INF    1 [topic/channel] msg")
+// or by the consumer ("INF [topic/channel] msg") into the level and the message.
+// A line without a recognizable level prefix is Info and is returned as is.
+func parseLogLine(line string) (nsq.LogLevel, string) {
+	prefix, rest, _ := strings.Cut(line, " ")
+
+	var lvl nsq.LogLevel
+	switch prefix {
+	case "DBG":
+		lvl = nsq.LogLevelDebug
+	case "INF":
+		lvl = nsq.LogLevelInfo
+	case "WRN":
+		lvl = nsq.LogLevelWarning
+	case "ERR":
+		lvl = nsq.LogLevelError
+	default:
+		return nsq.LogLevelInfo, line
+	}
+
+	rest = strings.TrimLeft(rest, " ")
+
+	// The go-nsq client ID
+	if id, after, ok := strings.Cut(rest, " "); ok {
+		if _, err := strconv.Atoi(id); err == nil {
+			rest = after
+		}
+	}
+
+	// [topic/channel]
+	if strings.HasPrefix(rest, "[") {
+		if i := strings.Index(rest, "] "); i >= 0 {
+			rest = rest[i+2:]
+		}
+	}
+
+	return lvl, rest
+}
@@ -0,0 +1,112 @@
+package consumer_test
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// RecordHandler keeps the records logged at or above a given level.
+type recordHandler struct {
+	level slog.Level
+
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, l slog.Level) bool { return l >= h.level }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler           { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler                { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// Find returns the first record with a message containing s.
+func (h *recordHandler) find(s string) (slog.Record, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range h.records {
+		if strings.Contains(r.Message, s) {
+			return r, true
+		}
+	}
+	return slog.Record{}, false
+}
+
+func attrs(r slog.Record) map[string]string {
+	m := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		m[a.Key] = a.Value.String()
+		return true
+	})
+	return m
+}
+
+func TestSlogLoggerGoNSQError(t *testing.T) {
+	h := &recordHandler{level: slog.LevelInfo}
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetSlogLogger(slog.New(h))
+	c.Set("nsqlookupd", fakeLookupd(t, unreachableAddr(t)))
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	var (
+		r  slog.Record
+		ok bool
+	)
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if r, ok = h.find("error connecting to nsqd"); ok {
+			break
+		}
+	}
+	if !ok {
+		t.Fatal("the go-nsq connection error is not logged")
+	}
+
+	if r.Level != slog.LevelError {
+		t.Errorf("got level %v, want %v", r.Level, slog.LevelError)
+	}
+	if strings.HasPrefix(r.Message, "ERR") || strings.Contains(r.Message, "[orders/billing]") {
+		t.Errorf("the go-nsq prefix is not stripped: %q", r.Message)
+	}
+	if a := attrs(r); a["topic"] != "orders" || a["channel"] != "billing" {
+		t.Errorf("got attributes %v", a)
+	}
+}
+
+func TestSlogLoggerLevel(t *testing.T) {
+	for _, tt := range []struct {
+		level slog.Level
+		want  string
+	}{
+		{slog.LevelDebug, "debug"},
+		{slog.LevelInfo, "info"},
+		{slog.LevelWarn, "warning"},
+		{slog.LevelError, "error"},
+	} {
+		c := consumer.NewConsumer("orders", "billing")
+		c.SetSlogLogger(slog.New(&recordHandler{level: tt.level}))
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		c.RegisterFlags(fs, "")
+
+		if got := fs.Lookup("log-level").Value.String(); got != tt.want {
+			t.Errorf("slog level %v: got go-nsq level %s, want %s", tt.level, got, tt.want)
+		}
+	}
+}
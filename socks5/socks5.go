@@ -0,0 +1,55 @@
+// Package socks5 allows a consumer to reach nsqlookupds through a SOCKS5 proxy.
+//
+// Only the nsqlookupd HTTP queries are proxied. go-nsq dials nsqd TCP
+// connections by itself and does not accept a custom dialer, so the nsqd
+// addresses (configured or discovered) must still be reachable directly.
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// WithSOCKS5Proxy installs an nsqlookupd HTTP client that connects
+// through the SOCKS5 proxy at a given address. Auth may be nil.
+func WithSOCKS5Proxy(c *consumer.Consumer, addr string, auth *proxy.Auth) error {
+	client, err := NewHTTPClient(addr, auth, time.Minute)
+	if err != nil {
+		return err
+	}
+	c.WithLookupdHTTPClient(client)
+
+	return nil
+}
+
+// NewHTTPClient returns an HTTP client that connects through
+// the SOCKS5 proxy at a given address.
+func NewHTTPClient(addr string, auth *proxy.Auth, timeout time.Duration) (*http.Client, error) {
+	d, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("socks5 dialer does not support contexts")
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return cd.DialContext(ctx, network, addr)
+		},
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
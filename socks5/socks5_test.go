@@ -0,0 +1,242 @@
+package socks5_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"golang.org/x/net/proxy"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/socks5"
+)
+
+// SocksServer is a minimal SOCKS5 proxy supporting CONNECT
+// with no or username/password authentication.
+type socksServer struct {
+	ln   net.Listener
+	auth *proxy.Auth
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocksServer(t *testing.T, auth *proxy.Auth) *socksServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &socksServer{ln: ln, auth: auth}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *socksServer) connected() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, methods
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, hdr[1])); err != nil {
+		return
+	}
+
+	if s.auth == nil {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		if !s.authenticate(conn) {
+			return
+		}
+	}
+
+	// Request: version, command, reserved, address type
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil || req[1] != 1 {
+		return
+	}
+
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+
+	var port uint16
+	if err := binary.Read(conn, binary.BigEndian, &port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(up, conn)
+	io.Copy(conn, up)
+}
+
+func (s *socksServer) authenticate(conn net.Conn) bool {
+	read := func() string {
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return ""
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return ""
+		}
+		return string(b)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+		return false
+	}
+	user, password := read(), read()
+
+	if user != s.auth.User || password != s.auth.Password {
+		conn.Write([]byte{1, 1})
+		return false
+	}
+	conn.Write([]byte{1, 0})
+
+	return true
+}
+
+func newLookupd(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"channels":[],"producers":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestHTTPClientThroughProxy(t *testing.T) {
+	lookupd := newLookupd(t)
+	s := newSocksServer(t, nil)
+
+	client, err := socks5.NewHTTPClient(s.ln.Addr().String(), nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get(lookupd.URL + "/lookup?topic=orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %s", resp.Status)
+	}
+
+	want := lookupd.Listener.Addr().String()
+	if got := s.connected(); len(got) != 1 || got[0] != want {
+		t.Errorf("proxy connected to %v, want [%s]", got, want)
+	}
+}
+
+func TestHTTPClientProxyAuth(t *testing.T) {
+	lookupd := newLookupd(t)
+	s := newSocksServer(t, &proxy.Auth{User: "nsq", Password: "secret"})
+
+	client, err := socks5.NewHTTPClient(s.ln.Addr().String(), &proxy.Auth{User: "nsq", Password: "secret"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(lookupd.URL)
+	if err != nil {
+		t.Fatalf("with valid credentials: %v", err)
+	}
+	resp.Body.Close()
+
+	client, err = socks5.NewHTTPClient(s.ln.Addr().String(), &proxy.Auth{User: "nsq", Password: "wrong"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(lookupd.URL); err == nil {
+		resp.Body.Close()
+		t.Error("expected the proxy to reject invalid credentials")
+	}
+}
+
+func TestWithSOCKS5Proxy(t *testing.T) {
+	lookupd := newLookupd(t)
+	s := newSocksServer(t, nil)
+
+	c := consumer.NewConsumer("orders", "billing")
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.Set("nsqlookupd", lookupd.Listener.Addr().String())
+	if err := socks5.WithSOCKS5Proxy(c, s.ln.Addr().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Start(consumer.HandlerFunc(func(*nsq.Message) error { return nil })); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.connected()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nsqlookupd is not queried through the proxy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DefaultStarvationPollInterval is how often the consumer checks
+// for starvation unless the `starvation_poll_interval` option is set.
+const DefaultStarvationPollInterval = time.Second
+
+// OnStarvation registers a callback fired when the consumer becomes starved.
+//
+// In NSQ terms a consumer is starved when, on any of its connections,
+// the number of messages in flight has reached 85% of the RDY count:
+// the handlers cannot keep up and nsqd is about to stop sending messages
+// until some are finished. Being starved for long is a signal to scale out.
+//
+// The state is polled every DefaultStarvationPollInterval (or the
+// `starvation_poll_interval` option). The callback is fired once when
+// the consumer becomes starved, and again only after it has recovered.
+// It runs in its own goroutine.
+func (c *Consumer) OnStarvation(fn func()) {
+	c.cbMu.Lock()
+	c.starvationCbs = append(c.starvationCbs, fn)
+	c.cbMu.Unlock()
+}
+
+func (c *Consumer) watchStarvation(clients []*nsq.Consumer, done <-chan struct{}) {
+	interval := c.starvationInterval
+	if interval <= 0 {
+		interval = DefaultStarvationPollInterval
+	}
+
+	var starved bool
+
+	for {
+		select {
+		case <-c.clock.After(interval):
+		case <-done:
+			return
+		}
+
+		switch s := anyStarved(clients); {
+		case s && !starved:
+			c.cbMu.Lock()
+			cbs := c.starvationCbs
+			c.cbMu.Unlock()
+
+			for _, fn := range cbs {
+				go fn()
+			}
+			starved = true
+		case !s:
+			starved = false
+		}
+	}
+}
+
+func anyStarved(clients []*nsq.Consumer) bool {
+	for _, client := range clients {
+		if client.IsStarved() {
+			return true
+		}
+	}
+	return false
+}
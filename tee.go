@@ -0,0 +1,35 @@
+package consumer
+
+import (
+	"io"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Tee returns a middleware that writes the body of each message,
+// followed by a newline, to a given writer before handling it.
+//
+// It is a debug aid to see what a consumer actually receives, not
+// a production-grade logging facility: writes are serialized with a mutex,
+// so a slow writer slows down all handlers. Write errors are ignored.
+func Tee(w io.Writer) Middleware {
+	var mu sync.Mutex
+
+	return func(next nsq.Handler) nsq.Handler {
+		return HandlerFunc(func(m *nsq.Message) error {
+			mu.Lock()
+			w.Write(m.Body)
+			w.Write([]byte{'\n'})
+			mu.Unlock()
+
+			return next.HandleMessage(m)
+		})
+	}
+}
+
+// WithTeeOutput registers the Tee middleware writing to a given writer.
+func (c *Consumer) WithTeeOutput(w io.Writer) *Consumer {
+	c.Use(Tee(w))
+	return c
+}
@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// ErrHandlerTimeout is the error of a message whose handler has not returned
+// within the handler timeout (see SetHandlerTimeout).
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// SetHandlerTimeout limits the time a handler may take per message.
+// If it has not returned in time, the message is requeued as failed
+// with the default delay and the overrun is logged. The handler is
+// abandoned: its goroutine keeps running, since Go cannot stop it,
+// but its response to the message, if any, is ignored. Such goroutines
+// are counted by AbandonedHandlers.
+//
+// The timeout should be shorter than msg_timeout. It wins over touching:
+// a touched message still fails once the timeout is reached.
+// Zero means no timeout.
+func (c *Consumer) SetHandlerTimeout(d time.Duration) {
+	c.Set("handler_timeout", d)
+}
+
+// AbandonedHandlers returns the total number of handlers abandoned on timeout
+// and the number of those still running.
+func (c *Consumer) AbandonedHandlers() (total, running uint64) {
+	return c.abandoned.total.Load(), c.abandoned.running.Load()
+}
+
+type abandoned struct {
+	total   atomic.Uint64
+	running atomic.Uint64
+}
+
+// CallNext calls the rest of the handler chain, abandoning it on timeout.
+func (h *wrappedHandler) callNext(m *nsq.Message) error {
+	timeout := h.c.handlerTimeout
+	if timeout <= 0 {
+		return h.next.HandleMessage(m)
+	}
+
+	// 0 running, 1 returned, 2 abandoned
+	var state atomic.Int32
+
+	done := make(chan error, 1)
+	go func() {
+		err := h.next.HandleMessage(m)
+		if !state.CompareAndSwap(0, 1) {
+			h.c.abandoned.running.Add(^uint64(0))
+			h.c.logf(nsq.LogLevelInfo, "abandoned handler of %s has returned", MessageInfo(m))
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-h.c.clock.After(timeout):
+	}
+
+	// Counted first, so that the handler returning right now
+	// never decrements it below zero
+	h.c.abandoned.running.Add(1)
+	if !state.CompareAndSwap(0, 2) {
+		// It has just returned
+		h.c.abandoned.running.Add(^uint64(0))
+		return <-done
+	}
+	h.c.abandoned.total.Add(1)
+
+	h.c.logMsgf(m, nsq.LogLevelWarning, "handler of %s has not returned in %s, requeueing", MessageInfo(m), timeout)
+
+	// Requeueing marks the message as responded,
+	// so that a late response of the handler is a no-op.
+	if !m.HasResponded() {
+		m.DisableAutoResponse()
+		m.Requeue(-1)
+	}
+
+	return ErrHandlerTimeout
+}
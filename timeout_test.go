@@ -0,0 +1,82 @@
+package consumer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	clk := newFakeClock()
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.SetHandlerTimeout(time.Minute)
+
+	m := consumertest.NewMessage([]byte("1"), nil)
+
+	returned, release := make(chan struct{}), make(chan struct{})
+	done := deliverAsync(c, consumer.HandlerFunc(func(m *nsq.Message) error {
+		defer close(returned)
+		<-release
+		m.Finish()
+		return nil
+	}), m)
+
+	clk.waitTimers(t, 1)
+	clk.advance(time.Minute - time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("the handler is abandoned before the timeout: %v", err)
+	default:
+	}
+
+	clk.advance(time.Second)
+	if err := <-done; !errors.Is(err, consumer.ErrHandlerTimeout) {
+		t.Fatalf("got error %v, want %v", err, consumer.ErrHandlerTimeout)
+	}
+
+	d := consumertest.DelegateOf(m)
+	if ok, delay, _ := d.Requeued(); !ok || delay != -1 {
+		t.Errorf("got requeued %t with delay %s, want the default delay", ok, delay)
+	}
+	if total, running := c.AbandonedHandlers(); total != 1 || running != 1 {
+		t.Errorf("got %d abandoned handlers, %d running, want 1 and 1", total, running)
+	}
+
+	// The late response is ignored
+	close(release)
+	wait(t, returned, "the abandoned handler to return")
+	for deadline := time.Now().Add(testTimeout); ; time.Sleep(time.Millisecond) {
+		if _, running := c.AbandonedHandlers(); running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the returned handler is still counted as running")
+		}
+	}
+	if d.Finished() {
+		t.Error("the abandoned handler finished the requeued message")
+	}
+}
+
+func TestHandlerTimeoutNotReached(t *testing.T) {
+	clk := newFakeClock()
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+	c.SetHandlerTimeout(time.Minute)
+
+	d, err := consumertest.Handle(c, nopHandler, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Finished() {
+		t.Error("the message is not finished")
+	}
+	if total, _ := c.AbandonedHandlers(); total != 0 {
+		t.Errorf("got %d abandoned handlers", total)
+	}
+}
@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// SetTLSConfig applies a fully built TLS configuration and enables TLS.
+// A nil config disables TLS.
+func (c *Consumer) SetTLSConfig(cfg *tls.Config) {
+	c.Set("tls_config", cfg)
+	c.Set("tls_v1", cfg != nil)
+}
+
+// TLSFiles holds the paths set by the `tls_cert_file`, `tls_key_file`
+// and `tls_ca_file` options.
+type tlsFiles struct {
+	cert string
+	key  string
+	ca   string
+}
+
+// SetTLSFile handles the `tls_*_file` options: it loads the PEM files
+// into the TLS configuration and enables TLS. The key pair is loaded
+// once both paths are known, whichever is set first.
+func (c *Consumer) setTLSFile(option, path string) error {
+	switch option {
+	case "tls_cert_file":
+		c.tlsFiles.cert = path
+	case "tls_key_file":
+		c.tlsFiles.key = path
+	case "tls_ca_file":
+		c.tlsFiles.ca = path
+	}
+
+	cfg := c.config.TlsConfig
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+		c.config.TlsConfig = cfg
+	}
+	c.config.TlsV1 = true
+
+	if option == "tls_ca_file" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates found", path)
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+
+	if f := c.tlsFiles; f.cert != "" && f.key != "" {
+		cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return fmt.Errorf("%s, %s: %v", f.cert, f.key, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return nil
+}
+
+// CheckTLSFiles reports a certificate without a key or vice versa.
+func (c *Consumer) checkTLSFiles() error {
+	switch f := c.tlsFiles; {
+	case f.cert != "" && f.key == "":
+		return fmt.Errorf(`"tls_cert_file" is set without "tls_key_file"`)
+	case f.key != "" && f.cert == "":
+		return fmt.Errorf(`"tls_key_file" is set without "tls_cert_file"`)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package consumer_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// TLSFixture is a self-signed certificate for 127.0.0.1, used both
+// by the fake nsqd and the consumer, written to PEM files.
+type tlsFixture struct {
+	cert tls.Certificate
+	pool *x509.CertPool
+
+	certFile, keyFile string
+}
+
+func newTLSFixture(t *testing.T) *tlsFixture {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nsqd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	f := &tlsFixture{
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(f.certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.cert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	f.pool = x509.NewCertPool()
+	f.pool.AppendCertsFromPEM(certPEM)
+
+	return f
+}
+
+// NSQD returns a fake nsqd requiring TLS with a client certificate.
+func (f *tlsFixture) nsqd(t *testing.T) *fakeNSQD {
+	d := newFakeNSQD(t)
+	d.tls = &tls.Config{
+		Certificates: []tls.Certificate{f.cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    f.pool,
+	}
+	return d
+}
+
+func TestTLSFiles(t *testing.T) {
+	f := newTLSFixture(t)
+	d := f.nsqd(t)
+
+	c := newNSQDConsumer(t, d)
+	c.Set("tls_cert_file", f.certFile)
+	c.Set("tls_key_file", f.keyFile)
+	c.Set("tls_ca_file", f.certFile)
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.expect("FIN")
+}
+
+func TestSetTLSConfig(t *testing.T) {
+	f := newTLSFixture(t)
+	d := f.nsqd(t)
+
+	c := newNSQDConsumer(t, d)
+	c.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{f.cert},
+		RootCAs:      f.pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	if err := c.Start(nopHandler); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d.publish("1", nil, 1)
+	d.expect("FIN")
+}
+
+func TestTLSUnknownAuthority(t *testing.T) {
+	f := newTLSFixture(t)
+	d := f.nsqd(t)
+
+	other := newTLSFixture(t)
+
+	c := newNSQDConsumer(t, d)
+	c.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{f.cert},
+		RootCAs:      other.pool,
+	})
+
+	if err := c.Start(nopHandler); err == nil {
+		c.Stop()
+		t.Fatal("expected the handshake with an unknown authority to fail")
+	}
+}
+
+func TestTLSFileErrors(t *testing.T) {
+	f := newTLSFixture(t)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	for _, tc := range []struct {
+		name    string
+		options map[string]interface{}
+		err     string
+	}{
+		{
+			name:    "cert without key",
+			options: map[string]interface{}{"tls_cert_file": f.certFile},
+			err:     `"tls_cert_file" is set without "tls_key_file"`,
+		},
+		{
+			name:    "key without cert",
+			options: map[string]interface{}{"tls_key_file": f.keyFile},
+			err:     `"tls_key_file" is set without "tls_cert_file"`,
+		},
+		{
+			name:    "missing CA",
+			options: map[string]interface{}{"tls_ca_file": missing},
+			err:     missing,
+		},
+		{
+			name:    "key as CA",
+			options: map[string]interface{}{"tls_ca_file": f.keyFile},
+			err:     "no PEM certificates found",
+		},
+		{
+			name:    "mismatched pair",
+			options: map[string]interface{}{"tls_cert_file": f.certFile, "tls_key_file": newTLSFixture(t).keyFile},
+			err:     f.certFile,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := consumer.NewConsumer("orders", "billing")
+			c.SetLogger(nil, nsq.LogLevelError)
+			c.Set("nsqlookupd", "127.0.0.1:4161")
+			c.SetMap(tc.options)
+
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// AddTopic adds a topic to be consumed from the same channel, in addition
+// to the `topic` option. Every topic gets its own NSQ Consumer sharing
+// the configuration, logger and handler.
+//
+// It has to be called before Start.
+func (c *Consumer) AddTopic(topic string) *Consumer {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	if err := c.immutable("topics"); err != nil {
+		c.logf(nsq.LogLevelWarning, "%v", err)
+		return c
+	}
+
+	c.addTopic(topic)
+
+	return c
+}
+
+// AddTopic is AddTopic with c.setMu held.
+func (c *Consumer) addTopic(topic string) {
+	for _, t := range c.topics {
+		if t == topic {
+			return
+		}
+	}
+	c.topics = append(c.topics, topic)
+}
+
+// AllTopics returns the primary topic followed by the added ones, without duplicates.
+func (c *Consumer) allTopics() []string {
+	topics := []string{c.topic}
+	for _, t := range c.topics {
+		if t != c.topic {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// TopicLabel returns the topics joined by comma, it is used in logs and metrics.
+func (c *Consumer) topicLabel() string {
+	return strings.Join(c.allTopics(), ",")
+}
+
+// SumStats adds up the stats of all clients.
+func sumStats(clients []*nsq.Consumer) nsq.ConsumerStats {
+	var stats nsq.ConsumerStats
+	for _, client := range clients {
+		s := client.Stats()
+		stats.MessagesReceived += s.MessagesReceived
+		stats.MessagesFinished += s.MessagesFinished
+		stats.MessagesRequeued += s.MessagesRequeued
+		stats.Connections += s.Connections
+	}
+	return stats
+}
+
+// Topics returns the consumed topics, the primary one first.
+func (c *Consumer) Topics() []string {
+	return c.allTopics()
+}
+
+// Channel returns the consumer channel.
+func (c *Consumer) Channel() string {
+	return c.channel
+}
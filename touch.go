@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DefaultAutoTouchMax is the default limit of the time a message is kept
+// alive by auto-touch. It is the default --max-msg-timeout of nsqd,
+// beyond which touching has no effect.
+const DefaultAutoTouchMax = 15 * time.Minute
+
+// EnableAutoTouch makes the consumer touch every message at a given interval
+// while its handler is running, so that long handlers do not exceed
+// msg_timeout. A zero interval means a bit under msg_timeout (see
+// WithContextFromMsgTimeout for the margin).
+//
+// Touching stops when the handler returns or the message is responded to,
+// and, with a warning, once the message has been kept alive for
+// `auto_touch_max` (default: 15m, the nsqd default of --max-msg-timeout).
+// A handler timeout, if any, wins: the message fails when it is reached.
+func (c *Consumer) EnableAutoTouch(interval time.Duration) {
+	c.Set("auto_touch", true)
+	if interval > 0 {
+		c.Set("auto_touch_interval", interval)
+	}
+}
+
+// AutoTouch touches a given message until the returned func is called.
+func (c *Consumer) autoTouch(m *nsq.Message) (stop func()) {
+	if !c.touch.enabled {
+		return func() {}
+	}
+
+	interval := c.touch.interval
+	if interval <= 0 {
+		interval = c.handlerDeadline()
+	}
+	max := c.touch.max
+	if max <= 0 {
+		max = DefaultAutoTouchMax
+	}
+
+	done := make(chan struct{})
+	start := c.clock.Now()
+
+	go func() {
+		for {
+			select {
+			case <-c.clock.After(interval):
+			case <-done:
+				return
+			}
+
+			if m.HasResponded() {
+				return
+			}
+			if c.clock.Now().Sub(start) >= max {
+				c.logMsgf(m, nsq.LogLevelWarning, "%s has been touched for %s, touching no more", MessageInfo(m), max)
+				return
+			}
+
+			m.Touch()
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+type autoTouch struct {
+	enabled  bool
+	interval time.Duration
+	max      time.Duration
+}
@@ -0,0 +1,210 @@
+package consumer_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/consumertest"
+)
+
+const touchInterval = 10 * time.Second
+
+// TouchedConsumer returns a consumer touching messages every touchInterval
+// of a given fake clock.
+func touchedConsumer(clk *fakeClock) *consumer.Consumer {
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+	c.SetLogger(nil, nsq.LogLevelError)
+	c.EnableAutoTouch(touchInterval)
+	return c
+}
+
+// DeliverAsync delivers a message in the background, see consumertest.Deliver.
+func deliverAsync(c *consumer.Consumer, h nsq.Handler, msgs ...*nsq.Message) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- consumertest.Deliver(c, h, msgs...) }()
+	return done
+}
+
+// BlockingHandler returns a handler running until release is closed.
+func blockingHandler(release <-chan struct{}) nsq.Handler {
+	return consumer.HandlerFunc(func(*nsq.Message) error {
+		<-release
+		return nil
+	})
+}
+
+func TestAutoTouch(t *testing.T) {
+	clk := newFakeClock()
+	c := touchedConsumer(clk)
+
+	m := consumertest.NewMessage([]byte("1"), nil)
+	d := consumertest.DelegateOf(m)
+
+	release := make(chan struct{})
+	done := deliverAsync(c, blockingHandler(release), m)
+
+	for i := 1; i <= 3; i++ {
+		clk.waitTimers(t, 1)
+		clk.advance(touchInterval)
+		// Touched before waiting for the next interval
+		clk.waitTimers(t, 1)
+		if n := d.Touches(); n != i {
+			t.Fatalf("the message was touched %d times in %d intervals", n, i)
+		}
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	clk.advance(touchInterval)
+	if n := d.Touches(); n != 3 {
+		t.Errorf("the message was touched %d more times after the handler returned", n-3)
+	}
+}
+
+func TestAutoTouchDisabled(t *testing.T) {
+	clk := newFakeClock()
+	c := consumer.NewConsumer("orders", "billing").WithClock(clk)
+	c.Set("auto_touch_interval", touchInterval)
+
+	if _, err := consumertest.Handle(c, nopHandler, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := clk.pending(); n != 0 {
+		t.Errorf("%d timers are set without auto_touch", n)
+	}
+}
+
+func TestAutoTouchAfterResponse(t *testing.T) {
+	clk := newFakeClock()
+	c := touchedConsumer(clk)
+
+	m := consumertest.NewMessage([]byte("1"), nil)
+
+	finished, release := make(chan struct{}), make(chan struct{})
+	done := deliverAsync(c, consumer.HandlerFunc(func(m *nsq.Message) error {
+		m.Finish()
+		close(finished)
+		<-release
+		return nil
+	}), m)
+
+	wait(t, finished, "the message to be finished")
+	clk.waitTimers(t, 1)
+	clk.advance(touchInterval)
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := consumertest.DelegateOf(m).Touches(); n != 0 {
+		t.Errorf("the message was touched %d times after Finish", n)
+	}
+}
+
+func TestAutoTouchMax(t *testing.T) {
+	clk := newFakeClock()
+	c := touchedConsumer(clk)
+	c.Set("auto_touch_max", 25*time.Second)
+
+	warned := make(chan struct{}, 1)
+	c.OnMessageLog(func(_ *nsq.Message, lvl nsq.LogLevel, line string) {
+		if lvl == nsq.LogLevelWarning && strings.Contains(line, "touching no more") {
+			warned <- struct{}{}
+		}
+	})
+
+	m := consumertest.NewMessage([]byte("1"), nil)
+
+	release := make(chan struct{})
+	done := deliverAsync(c, blockingHandler(release), m)
+
+	// Touched at 10s and 20s, given up at 30s
+	for i := 0; i < 3; i++ {
+		clk.waitTimers(t, 1)
+		clk.advance(touchInterval)
+	}
+	select {
+	case <-warned:
+	case <-time.After(testTimeout):
+		t.Fatal("no warning about the limit")
+	}
+	if n := clk.pending(); n != 0 {
+		t.Errorf("%d timers are set after the limit", n)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := consumertest.DelegateOf(m).Touches(); n != 2 {
+		t.Errorf("the message was touched %d times, want 2", n)
+	}
+}
+
+func TestAutoTouchGoroutines(t *testing.T) {
+	clk := newFakeClock()
+	c := touchedConsumer(clk)
+	c.Set("concurrency", 8)
+
+	msgs := make([]*nsq.Message, 32)
+	touched := make(map[nsq.MessageID]chan struct{}, len(msgs))
+	for i := range msgs {
+		msgs[i] = consumertest.NewMessage([]byte{byte(i)}, nil)
+		touched[msgs[i].ID] = make(chan struct{})
+	}
+	c.OnMessageTouched(func(m *nsq.Message) {
+		// A message may be touched again before its handler returns
+		select {
+		case <-touched[m.ID]:
+		default:
+			close(touched[m.ID])
+		}
+	})
+
+	before := runtime.NumGoroutine()
+
+	// Every handler runs until its message is touched
+	done := deliverAsync(c, consumer.HandlerFunc(func(m *nsq.Message) error {
+		<-touched[m.ID]
+		return nil
+	}), msgs...)
+
+	for deadline := time.Now().Add(testTimeout); ; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Millisecond):
+			if time.Now().After(deadline) {
+				t.Fatal("the messages are not handled")
+			}
+			clk.advance(touchInterval)
+			continue
+		}
+		break
+	}
+
+	for _, m := range msgs {
+		if consumertest.DelegateOf(m).Touches() == 0 {
+			t.Errorf("msg %x was not touched", m.ID[:1])
+		}
+	}
+
+	// The touching goroutines exit once the handlers have returned
+	deadline := time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left after handling, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
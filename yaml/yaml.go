@@ -0,0 +1,55 @@
+// Package yaml loads consumer options from YAML.
+//
+// It lives in its own package so that the YAML module is
+// pulled only by those who use it.
+package yaml
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	consumer "github.com/0xef53/nsq-consumer"
+)
+
+// Load sets the consumer options from a flat YAML mapping of option name
+// to value, with the same names as consumer.Set:
+//
+//	topic: orders
+//	channel: billing
+//	nsqlookupds:
+//	  - lookupd-1:4161
+//	  - lookupd-2:4161
+//	concurrency: 4
+//	max_in_flight: 16
+//	lookupd_poll_interval: 15s
+//
+// Address lists may be given as a sequence or as a string separated
+// by comma or space. Unknown keys are passed to nsq.Config as with Set.
+//
+// Malformed YAML is returned immediately. Errors of the options themselves
+// are returned by Start() as with Set.
+func Load(c *consumer.Consumer, r io.Reader) error {
+	var options map[string]interface{}
+
+	if err := yaml.NewDecoder(r).Decode(&options); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode YAML options: %v", err)
+	}
+
+	c.LoadMap(options)
+
+	return nil
+}
+
+// LoadFile is like Load but reads the options from a file.
+func LoadFile(c *consumer.Consumer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return Load(c, f)
+}
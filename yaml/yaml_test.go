@@ -0,0 +1,81 @@
+package yaml_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	consumer "github.com/0xef53/nsq-consumer"
+	"github.com/0xef53/nsq-consumer/yaml"
+)
+
+const config = `
+topic: orders
+channel: billing
+nsqlookupds:
+  - lookupd-1:4161
+  - lookupd-2:4161
+nsqds: "nsqd-1:4150, nsqd-2:4150"
+concurrency: 4
+max_in_flight: 16
+lookupd_fallback: true
+lookupd_poll_interval: 15s
+`
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nsq.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := consumer.NewConsumer("", "")
+	if err := yaml.LoadFile(c, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := c.ConfigMap()
+	for opt, want := range map[string]interface{}{
+		"topic":                 "orders",
+		"channel":               "billing",
+		"nsqlookupds":           []string{"lookupd-1:4161", "lookupd-2:4161"},
+		"nsqds":                 []string{"nsqd-1:4150", "nsqd-2:4150"},
+		"concurrency":           4,
+		"max_in_flight":         16,
+		"lookupd_fallback":      true,
+		"lookupd_poll_interval": 15 * time.Second,
+	} {
+		if !reflect.DeepEqual(cfg[opt], want) {
+			t.Errorf("%s = %#v, want %#v", opt, cfg[opt], want)
+		}
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	if err := yaml.Load(c, strings.NewReader("topic: [orders")); err == nil {
+		t.Error("expected malformed YAML to be rejected")
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	if err := yaml.Load(c, strings.NewReader("")); err != nil {
+		t.Errorf("empty input: %v", err)
+	}
+}
+
+func TestLoadInvalidOption(t *testing.T) {
+	c := consumer.NewConsumer("orders", "billing")
+	if err := yaml.Load(c, strings.NewReader("nsqlookupd: lookupd-1:4161\nmax_in_flight: many\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("expected the invalid max_in_flight to be reported")
+	}
+}